@@ -0,0 +1,14 @@
+package app
+
+import (
+	"encoding/json"
+
+	"github.com/platipy-io/d2s/server"
+)
+
+// csrfHeaders returns the hx-headers value making every HTMX request carry
+// the CSRF token of the session.
+func csrfHeaders(ctx *server.Context) string {
+	headers, _ := json.Marshal(map[string]string{server.CSRFHeader: ctx.CSRFToken()})
+	return string(headers)
+}
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/a-h/templ"
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/server"
+	"github.com/platipy-io/d2s/types"
+)
+
+// maxCollectionName bounds the length of the name of a collection, in
+// characters.
+const maxCollectionName = 100
+
+// reorderTrigger posts the order of a list once one of its repositories was
+// moved, dropping it on a collection is posted to the collection instead.
+const reorderTrigger = "end[from === to && oldIndex !== newIndex]"
+
+var (
+	ErrInvalidCollection = xerrors.Message("invalid collection")
+	ErrInvalidRepository = xerrors.Message("invalid repository")
+)
+
+func collectionURL(collection *types.Collection) string {
+	return "/collections/" + strconv.FormatInt(collection.ID, 10)
+}
+
+func sharedURL(collection *types.Collection) string {
+	return "/shared/" + collection.ShareToken
+}
+
+// oobAttrs swaps a component out of band when oob is set.
+func oobAttrs(oob bool) templ.Attributes {
+	if !oob {
+		return nil
+	}
+	return templ.Attributes{"hx-swap-oob": "true"}
+}
+
+// loadCollection loads the {id} collection of the user, ErrNotFound is
+// returned when there is none.
+func loadCollection(ctx *server.Context) (*types.Collection, error) {
+	id, err := strconv.ParseInt(ctx.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, xerrors.WithWrapper(data.ErrNotFound, xerrors.New(ErrInvalidCollection, ctx.URLParam("id")))
+	}
+	return ctx.DB.Collection(ctx.Context(), ctx.User.ID, id)
+}
+
+// newCollectionNotFound does not tell missing collections from the ones of
+// other users.
+func newCollectionNotFound(err error) error {
+	if errors.Is(err, data.ErrNotFound) {
+		return New404HTTPError(err)
+	}
+	return err
+}
+
+// collectionError logs err unless the collection is gone, and tells the user
+// why it could not be changed.
+func collectionError(ctx *server.Context, err error) string {
+	if errors.Is(err, data.ErrNotFound) {
+		return "This collection does not exist anymore"
+	}
+	ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed loading collection")
+	return "Change could not be saved"
+}
+
+// toastError renders msg in the toasts, whatever the target of the request
+// was.
+func toastError(ctx *server.Context, msg string) error {
+	ctx.ResponseWriter.Header().Set("HX-Retarget", "#toasts")
+	ctx.ResponseWriter.Header().Set("HX-Reswap", "afterbegin")
+	return ctx.Render(NewToastDanger(msg))
+}
+
+// Collection renders the repositories of a collection of the user.
+func Collection(ctx *server.Context) error {
+	span := ctx.NewSpan("collection")
+	defer span.End()
+	defer ctx.LogWrapper("collection endpoint")()
+	if err := requireGithub(ctx); err != nil {
+		return err
+	}
+	collection, err := loadCollection(ctx)
+	if err != nil {
+		return newCollectionNotFound(err)
+	}
+	repos, err := ctx.DB.CollectionRepositories(ctx.Context(), collection)
+	if err != nil {
+		return err
+	}
+	return ctx.Render(BaseTplt(ctx, CollectionTplt(collection, repos, true)))
+}
+
+// SharedCollection renders a collection read-only to anyone holding its share
+// link.
+func SharedCollection(ctx *server.Context) error {
+	collection, err := ctx.DB.SharedCollection(ctx.Context(), ctx.URLParam("token"))
+	if err != nil {
+		return newCollectionNotFound(err)
+	}
+	repos, err := ctx.DB.CollectionRepositories(ctx.Context(), collection)
+	if err != nil {
+		return err
+	}
+	// the link is only known to the people it was given to
+	ctx.ResponseWriter.Header().Set("X-Robots-Tag", "noindex")
+	return ctx.Render(BaseTplt(ctx, CollectionTplt(collection, repos, false)))
+}
+
+// CollectionCreate creates a collection named after the posted name and
+// renders the updated list of collections.
+func CollectionCreate(ctx *server.Context) error {
+	if ctx.User == nil {
+		return toastError(ctx, "You must be logged in to create collections")
+	}
+	name := strings.TrimSpace(ctx.PostFormValue("name"))
+	if name == "" || utf8.RuneCountInString(name) > maxCollectionName {
+		return toastError(ctx, "Collection names have between 1 and "+strconv.Itoa(maxCollectionName)+" characters")
+	}
+	err := ctx.DB.CreateCollection(ctx.Context(), &types.Collection{UserID: ctx.User.ID, Name: name})
+	if errors.Is(err, data.ErrDuplicate) {
+		return toastError(ctx, "A collection with this name already exists")
+	} else if err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed creating collection")
+		return toastError(ctx, "Collection could not be created")
+	}
+	collections, err := ctx.DB.Collections(ctx.Context(), ctx.User.ID)
+	if err != nil {
+		return err
+	}
+	return ctx.Render(CollectionList(collections, false))
+}
+
+// CollectionAdd adds the repository posted as item field, on drop, to the
+// collection. The list of collections is swapped along to update the counts.
+func CollectionAdd(ctx *server.Context) error {
+	if ctx.User == nil {
+		return ctx.Render(NewToastDanger("You must be logged in to edit collections"))
+	}
+	collection, err := loadCollection(ctx)
+	if err != nil {
+		return ctx.Render(NewToastDanger(collectionError(ctx, err)))
+	}
+	repoID, err := strconv.ParseInt(ctx.PostFormValue("item"), 10, 64)
+	if err != nil {
+		ctx.Logger.Warn().Ctx(ctx.Context()).Err(xerrors.New(ErrInvalidRepository, ctx.PostFormValue("item"))).
+			Msg("rejecting repository")
+		return ctx.Render(NewToastDanger("Invalid repository, please reload the page"))
+	}
+	err = ctx.DB.AddToCollection(ctx.Context(), ctx.User.ID, collection.ID, repoID)
+	if errors.Is(err, data.ErrNotFound) {
+		return ctx.Render(NewToastDanger("This repository is not starred anymore"))
+	} else if err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed adding to collection")
+		return ctx.Render(NewToastDanger("Change could not be saved"))
+	}
+	collections, err := ctx.DB.Collections(ctx.Context(), ctx.User.ID)
+	if err != nil {
+		return err
+	}
+	return ctx.Render(templ.Join(NewToastSuccess("Added to "+collection.Name), CollectionList(collections, true)))
+}
+
+// CollectionPost saves the order of the collection, posted as item fields on
+// drag end.
+func CollectionPost(ctx *server.Context) error {
+	if ctx.User == nil {
+		return ctx.Render(NewToastDanger("You must be logged in to reorder repositories"))
+	}
+	collection, err := loadCollection(ctx)
+	if err != nil {
+		return ctx.Render(NewToastDanger(collectionError(ctx, err)))
+	}
+	ids, err := parseOrder(ctx)
+	if err != nil {
+		ctx.Logger.Warn().Ctx(ctx.Context()).Err(err).Msg("rejecting repository order")
+		return ctx.Render(NewToastDanger("Invalid order, please reload the page"))
+	}
+	if err := ctx.DB.SaveCollectionOrder(ctx.Context(), ctx.User.ID, collection.ID, ids); err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed saving collection order")
+		return ctx.Render(NewToastDanger("Change could not be saved"))
+	}
+	return ctx.Render(NewToastSuccess("Change saved"))
+}
+
+// CollectionRemove takes the {repo} repository out of the collection, its
+// item is swapped with nothing.
+func CollectionRemove(ctx *server.Context) error {
+	if ctx.User == nil {
+		return toastError(ctx, "You must be logged in to edit collections")
+	}
+	collection, err := loadCollection(ctx)
+	if err != nil {
+		return toastError(ctx, collectionError(ctx, err))
+	}
+	repoID, err := strconv.ParseInt(ctx.URLParam("repo"), 10, 64)
+	if err != nil {
+		return toastError(ctx, "Invalid repository, please reload the page")
+	}
+	err = ctx.DB.RemoveFromCollection(ctx.Context(), ctx.User.ID, collection.ID, repoID)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed removing from collection")
+		return toastError(ctx, "Change could not be saved")
+	}
+	// already removed, the item is dropped all the same
+	return nil
+}
+
+// CollectionShare creates the public link of the collection when the posted
+// share field is true, and removes it otherwise.
+func CollectionShare(ctx *server.Context) error {
+	if ctx.User == nil {
+		return toastError(ctx, "You must be logged in to share collections")
+	}
+	collection, err := loadCollection(ctx)
+	if err != nil {
+		return toastError(ctx, collectionError(ctx, err))
+	}
+	share := ctx.PostFormValue("share") == "true"
+	collection.ShareToken, err = ctx.DB.ShareCollection(ctx.Context(), ctx.User.ID, collection.ID, share)
+	if err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed sharing collection")
+		return toastError(ctx, "Change could not be saved")
+	}
+	return ctx.Render(CollectionShareTplt(collection))
+}
+
+// CollectionDelete deletes the collection and sends the user back to the
+// index.
+func CollectionDelete(ctx *server.Context) error {
+	if ctx.User == nil {
+		return toastError(ctx, "You must be logged in to delete collections")
+	}
+	collection, err := loadCollection(ctx)
+	if err == nil {
+		err = ctx.DB.DeleteCollection(ctx.Context(), ctx.User.ID, collection.ID)
+	}
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed deleting collection")
+		return toastError(ctx, "Collection could not be deleted")
+	}
+	ctx.ResponseWriter.Header().Set("HX-Redirect", "/")
+	return nil
+}
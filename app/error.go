@@ -0,0 +1,113 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/server"
+)
+
+// HTTPError is an error answered with its status code, Msg is shown to the
+// user while Err is only logged.
+type HTTPError struct {
+	Code int
+	Msg  string
+	Err  error
+}
+
+func New400HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusBadRequest, Msg: "The request provided is invalid", Err: err}
+}
+
+func New401HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusUnauthorized, Msg: "You must be logged in to access this page", Err: err}
+}
+
+func New403HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusForbidden, Msg: "You are not allowed to access this page", Err: err}
+}
+
+func New404HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusNotFound, Msg: "The page you are looking for does not exist", Err: err}
+}
+
+func New409HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusConflict, Msg: "The request conflicts with the current state", Err: err}
+}
+
+func New422HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusUnprocessableEntity, Msg: "The request provided could not be processed", Err: err}
+}
+
+func New429HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusTooManyRequests, Msg: "Too many requests, please try again later", Err: err}
+}
+
+func New500HTTPError(err error) HTTPError {
+	return HTTPError{Code: http.StatusInternalServerError, Msg: "We encountered an issue", Err: err}
+}
+
+// newRateLimitHTTPError tells the user when GitHub accepts calls again.
+func newRateLimitHTTPError(ctx *server.Context, rateLimit *github.RateLimitError, err error) HTTPError {
+	wait := max(time.Until(rateLimit.Reset).Round(time.Second), time.Second)
+	ctx.ResponseWriter.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	he := New429HTTPError(err)
+	he.Msg = "GitHub is limiting our requests, please try again in " + wait.String()
+	return he
+}
+
+func (he HTTPError) Error() string {
+	if he.Err == nil {
+		return http.StatusText(he.Code)
+	}
+	return he.Err.Error()
+}
+
+func (he HTTPError) Unwrap() error { return he.Err }
+
+// Render answers with problem details to the clients asking for JSON, and
+// with the error page otherwise. htmx requests only get the fragment of the
+// page.
+func (he HTTPError) Render(ctx *server.Context) {
+	if server.WantsJSON(ctx.Request) {
+		if err := ctx.WriteProblem(server.NewProblem(ctx.Request, he.Code, he.Msg)); err != nil {
+			ctx.Logger.Error().Ctx(ctx.Context()).Err(err).Msg("failed writing problem")
+		}
+		return
+	}
+	ctx.WriteHeader(he.Code)
+	component := ErrorTplt(he)
+	if !server.IsHTMX(ctx.Request) {
+		component = BaseTplt(ctx, component)
+	}
+	if err := ctx.Render(component); err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed rendering template")
+	}
+}
+
+// ErrorHandler renders the HTTPError wrapped in err, the errors without
+// status are answered as internal errors.
+func ErrorHandler(ctx *server.Context, err error) {
+	var errHTTP HTTPError
+	var rateLimit *github.RateLimitError
+	switch {
+	case errors.As(err, &errHTTP):
+	case errors.Is(err, server.ErrCSRF):
+		errHTTP = New403HTTPError(err)
+		errHTTP.Msg = "Your session expired, please reload the page"
+	case errors.As(err, &rateLimit):
+		errHTTP = newRateLimitHTTPError(ctx, rateLimit, err)
+	default:
+		errHTTP = New500HTTPError(err)
+	}
+	ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("handling error")
+	errHTTP.Render(ctx)
+}
+
+func NotFoundHandler(ctx *server.Context) {
+	ctx.Logger.Warn().Msg("path not found")
+	New404HTTPError(nil).Render(ctx)
+}
@@ -1,68 +1,275 @@
 package app
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/auth"
 	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/internal/linguist"
+	"github.com/platipy-io/d2s/internal/starred"
 	"github.com/platipy-io/d2s/server"
+	"github.com/platipy-io/d2s/types"
+)
+
+// reposPerPage is the number of repositories rendered at once, the next page
+// is requested when the end of the list is revealed.
+const reposPerPage = 30
+
+var (
+	ErrNotLoggedIn = xerrors.Message("not logged in with github")
+	ErrInvalidPage = xerrors.Message("invalid page")
 )
 
+// Starred is the listing rendered on the index of a logged in user.
+type Starred struct {
+	Repos     []*types.Repository
+	State     *types.SyncState
+	Query     data.StarredQuery
+	Languages []types.LanguageCount
+	// Collections are the drop targets of the repositories.
+	Collections []*types.Collection
+	// Next is the page following Repos, 0 on the last one.
+	Next int
+}
+
+// Sortable reports whether the repositories can be reordered: the custom
+// order is only saved from the full listing.
+func (s *Starred) Sortable() bool {
+	return s.Query.IsZero()
+}
+
+// NextURL requests the next page with the same filters, it is empty on the
+// last page.
+func (s *Starred) NextURL() string {
+	if s.Next == 0 {
+		return ""
+	}
+	values := queryValues(s.Query)
+	values.Set("page", strconv.Itoa(s.Next))
+	return "/repos?" + values.Encode()
+}
+
+// parseQuery reads the filters of the listing from the URL, unknown sorts
+// fall back to the custom order.
+func parseQuery(ctx *server.Context) data.StarredQuery {
+	values := ctx.URL.Query()
+	query := data.StarredQuery{
+		Search:   strings.TrimSpace(values.Get("q")),
+		Language: values.Get("language"),
+	}
+	switch sort := data.StarredSort(values.Get("sort")); sort {
+	case data.SortUpdated, data.SortName:
+		query.Sort = sort
+	}
+	return query
+}
+
+func queryValues(query data.StarredQuery) url.Values {
+	values := url.Values{}
+	if query.Search != "" {
+		values.Set("q", query.Search)
+	}
+	if query.Language != "" {
+		values.Set("language", query.Language)
+	}
+	if query.Sort != data.SortCustom {
+		values.Set("sort", string(query.Sort))
+	}
+	return values
+}
+
 func Index(ctx *server.Context) error {
 	span := ctx.NewSpan("index")
 	defer span.End()
 	defer ctx.LogWrapper("index endpoint")()
-	if ctx.User == nil {
+	// starred repositories are only known for users logged in with github
+	if ctx.User == nil || ctx.User.Provider != github.ProviderName {
 		return ctx.Render(BaseTplt(ctx, IndexTplt(nil, nil)))
 	}
-	repos, err := github.Starred(ctx.Context(), ctx.User)
-	if err != nil {
+	listing, err := loadStarred(ctx, 1)
+	if tokenUnusable(err) {
+		return relogin(ctx, err)
+	} else if err != nil {
 		return err
 	}
-	return ctx.Render(BaseTplt(ctx, IndexTplt(repos, nil)))
+	if listing.Languages, err = ctx.DB.StarredLanguages(ctx.Context(), ctx.User.ID, listing.Query); err != nil {
+		return err
+	}
+	// the filters only swap the listing, history restores need the full page
+	if ctx.Request.Header.Get("HX-Target") == "listing" && ctx.Request.Header.Get("HX-History-Restore-Request") == "" {
+		return ctx.Render(IndexListing(listing, true))
+	}
+	if listing.Collections, err = ctx.DB.Collections(ctx.Context(), ctx.User.ID); err != nil {
+		return err
+	}
+	return ctx.Render(BaseTplt(ctx, IndexTplt(listing, nil)))
 }
 
-func IndexPost(ctx *server.Context) error {
-	return ctx.Render(NewToastSuccess("Change saved"))
+// Repos renders a page of the starred repositories, appended to the index
+// listing.
+func Repos(ctx *server.Context) error {
+	if err := requireGithub(ctx); err != nil {
+		return err
+	}
+	page, err := strconv.Atoi(ctx.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return New400HTTPError(xerrors.New(ErrInvalidPage, ctx.URL.Query().Get("page")))
+	}
+	listing, err := loadStarred(ctx, page)
+	if tokenUnusable(err) {
+		return relogin(ctx, err)
+	} else if err != nil {
+		return err
+	}
+	return ctx.Render(RepoItems(listing.Repos, listing.NextURL()))
 }
 
-type HTTPError struct {
-	Code int
-	Msg  string
-	Err  error
+// languageStyle colors the dot of a language inline, the CDN build of Tailwind
+// does not generate arbitrary classes for values known only at runtime.
+func languageStyle(language string) string {
+	return "background-color: " + linguist.Color(language)
 }
 
-func New500HTTPError(err error) HTTPError {
-	return HTTPError{Code: http.StatusInternalServerError, Msg: "We encountered an issue", Err: err}
+// requireGithub rejects the visitors without starred repositories, only the
+// users logged in with github have some.
+func requireGithub(ctx *server.Context) error {
+	if ctx.User == nil || ctx.User.Provider != github.ProviderName {
+		return HTTPError{Code: http.StatusUnauthorized,
+			Msg: "You must be logged in with Github to list repositories", Err: ErrNotLoggedIn}
+	}
+	return nil
 }
 
-func New400HTTPError(err error) HTTPError {
-	return HTTPError{Code: http.StatusBadRequest, Msg: "The request provided is invalid", Err: err}
+// loadStarred serves the given page, starting at 1, of the repositories
+// matching the query of the request from the local copy kept by the syncer.
+// GitHub is only called on the first visit when there is no copy yet.
+func loadStarred(ctx *server.Context, page int) (*Starred, error) {
+	state, err := ctx.DB.SyncState(ctx.Context(), ctx.User.ID)
+	if errors.Is(err, data.ErrNotFound) {
+		syncer := starred.FromContext(ctx.Context())
+		if syncer == nil {
+			return nil, xerrors.New(ErrUnknownProvider, github.ProviderName)
+		}
+		state, err = syncer.Sync(ctx.Context(), ctx.User.ID)
+		// rate limits go to the error handler, which tells when to come back
+		if err != nil && state != nil && !tokenUnusable(err) && !errors.Is(err, github.ErrRateLimited) {
+			// recorded in the state, the page tells the listing is unavailable
+			ctx.Logger.Error().Ctx(ctx.Context()).Err(err).Msg("failed syncing starred repositories")
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	query := parseQuery(ctx)
+	// one more tells whether there is a next page
+	repos, err := ctx.DB.SearchStarred(ctx.Context(), ctx.User.ID, query,
+		reposPerPage+1, (page-1)*reposPerPage)
+	if err != nil {
+		return nil, err
+	}
+	listing := &Starred{Repos: repos, State: state, Query: query}
+	if len(repos) > reposPerPage {
+		listing.Repos, listing.Next = repos[:reposPerPage], page+1
+	}
+	return listing, nil
 }
 
-func (he HTTPError) Error() string { return he.Err.Error() }
+// tokenUnusable reports whether the session lost access to the provider: no
+// token stored, revoked refresh token, unknown encryption key...
+func tokenUnusable(err error) bool {
+	return errors.Is(err, data.ErrNotFound) || errors.Is(err, data.ErrDecrypt) ||
+		errors.Is(err, auth.ErrRefresh) || errors.Is(err, ErrUnknownProvider)
+}
 
-func (he HTTPError) Render(ctx *server.Context) {
-	ctx.WriteHeader(he.Code)
-	component := ErrorTplt(he)
-	if _, ok := ctx.Request.Header["Hx-Request"]; !ok {
-		component = BaseTplt(ctx, component)
-	}
-	if err := ctx.Render(component); err != nil {
-		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed rendering template")
+// relogin closes the session so the user goes through login again.
+func relogin(ctx *server.Context, err error) error {
+	ctx.Logger.Warn().Ctx(ctx.Context()).Err(err).Msg("token unusable, closing session")
+	if err := ctx.DeleteUser(); err != nil {
+		return New500HTTPError(err)
 	}
+	ctx.Redirect("/", http.StatusTemporaryRedirect)
+	return nil
 }
 
-func ErrorHandler(ctx *server.Context, err error) {
-	errHTTP := New500HTTPError(err)
-	if e, ok := err.(HTTPError); ok {
-		errHTTP = e
+// maxRepositories bounds the ordering a user can post.
+const maxRepositories = 1000
+
+var ErrInvalidOrder = xerrors.Message("invalid repository order")
+
+// IndexPost saves the order of the repositories, posted as item fields on
+// drag end. Only the pages loaded are posted, the other repositories keep
+// their order after them.
+func IndexPost(ctx *server.Context) error {
+	if ctx.User == nil {
+		return ctx.Render(NewToastDanger("You must be logged in to reorder repositories"))
+	}
+	ids, err := parseOrder(ctx)
+	if err != nil {
+		ctx.Logger.Warn().Ctx(ctx.Context()).Err(err).Msg("rejecting repository order")
+		return ctx.Render(NewToastDanger("Invalid order, please reload the page"))
 	}
-	ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(errHTTP.Err).Msg("handling error")
-	errHTTP.Render(ctx)
+	repos, err := ctx.DB.SearchStarred(ctx.Context(), ctx.User.ID, data.StarredQuery{}, 0, 0)
+	if err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed loading repositories")
+		return ctx.Render(NewToastDanger("Change could not be saved"))
+	}
+	ids = mergeOrder(ids, repos)
+	if err := ctx.DB.SaveRepositoryOrder(ctx.Context(), ctx.User.ID, ids); err != nil {
+		ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("failed saving repository order")
+		return ctx.Render(NewToastDanger("Change could not be saved"))
+	}
+	return ctx.Render(NewToastSuccess("Change saved"))
+}
 
+func parseOrder(ctx *server.Context) ([]int64, error) {
+	if err := ctx.ParseForm(); err != nil {
+		return nil, xerrors.WithWrapper(ErrInvalidOrder, err)
+	}
+	items := ctx.PostForm["item"]
+	if len(items) == 0 || len(items) > maxRepositories {
+		return nil, xerrors.New(ErrInvalidOrder, "item count", len(items))
+	}
+	ids := make([]int64, 0, len(items))
+	seen := make(map[int64]struct{}, len(items))
+	for _, item := range items {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, xerrors.New(ErrInvalidOrder, "item", item)
+		}
+		if _, ok := seen[id]; ok {
+			return nil, xerrors.New(ErrInvalidOrder, "duplicate item", item)
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
-func NotFoundHandler(ctx *server.Context) {
-	ctx.Logger.Warn().Msg("path not found")
-	HTTPError{Code: http.StatusNotFound, Msg: "The page you are looking for does not exist"}.Render(ctx)
+// mergeOrder drops the posted ids which are not starred by the user, then
+// appends the repositories which were not posted, in their current order.
+func mergeOrder(ids []int64, repos []*types.Repository) []int64 {
+	starred := make(map[int64]bool, len(repos))
+	for _, repo := range repos {
+		starred[repo.ID] = false
+	}
+	merged := make([]int64, 0, len(repos))
+	for _, id := range ids {
+		if _, ok := starred[id]; ok {
+			merged, starred[id] = append(merged, id), true
+		}
+	}
+	for _, repo := range repos {
+		if !starred[repo.ID] {
+			merged = append(merged, repo.ID)
+		}
+	}
+	return merged
 }
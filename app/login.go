@@ -2,11 +2,20 @@ package app
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/auth"
 	"github.com/platipy-io/d2s/internal/github"
 	"github.com/platipy-io/d2s/server"
 )
@@ -18,86 +27,236 @@ var (
 	ErrCookieGenerate  = xerrors.Message("failed to generate cookie: " + oauthStateCookieName)
 	ErrCookieRetrieval = xerrors.Message("failed to retrieve cookie: " + oauthStateCookieName)
 	ErrCookieUser      = xerrors.Message("failed to generate cookie: " + userCookieName)
-	ErrInvalidState    = xerrors.Message("invalid oauth github state")
-	ErrInvalidCode     = xerrors.Message("invalid oauth github code")
+	ErrInvalidState    = xerrors.Message("invalid oauth state")
+	ErrInvalidCode     = xerrors.Message("invalid oauth code")
+	ErrUnknownProvider = xerrors.Message("unknown authentication provider")
 
 	durationState = 20 * time.Minute
 )
 
 func Logout(ctx *server.Context) error {
-	ctx.DeleteUser()
-	ctx.Redirect("/", http.StatusTemporaryRedirect)
+	if err := ctx.DeleteUser(); err != nil {
+		return New500HTTPError(err)
+	}
+	if _, ok := ctx.Request.Header["Hx-Request"]; ok {
+		ctx.ResponseWriter.Header().Set("HX-Redirect", "/")
+		return nil
+	}
+	ctx.Redirect("/", http.StatusSeeOther)
 	return nil
 }
 
-func Login(ctx *server.Context) error {
-	// Create oauthState cookie
-	oauthState, err := generateStateOauthCookie(ctx)
-	if err != nil {
+// LogoutAll closes the sessions of the user on every device.
+func LogoutAll(ctx *server.Context) error {
+	if ctx.User == nil {
+		ctx.Redirect("/", http.StatusSeeOther)
+		return nil
+	}
+	if err := ctx.DeleteUserSessions(); err != nil {
 		return New500HTTPError(err)
 	}
-	/*
-		AuthCodeURL receive state that is a token to protect the user from CSRF attacks. You must always provide a non-empty string and
-		validate that it matches the the state query parameter on your redirect callback.
-	*/
-	ctx.Redirect(github.AuthCodeURL(oauthState), http.StatusTemporaryRedirect)
+	if _, ok := ctx.Request.Header["Hx-Request"]; ok {
+		ctx.ResponseWriter.Header().Set("HX-Redirect", "/")
+		return nil
+	}
+	ctx.Redirect("/", http.StatusSeeOther)
 	return nil
 }
 
-func LoginBypass(ctx *server.Context) error {
-	user, err := github.UserBypass(ctx.Context())
+// provider resolves the {provider} route parameter against the configured
+// providers.
+func provider(ctx *server.Context) (auth.Provider, error) {
+	name := ctx.URLParam("provider")
+	p, ok := auth.FromContext(ctx.Context()).Get(name)
+	if !ok {
+		return nil, New404HTTPError(xerrors.New(ErrUnknownProvider, name))
+	}
+	return p, nil
+}
+
+// Login starts the authorization code flow with PKCE. The secrets of the
+// flow are kept in an encrypted cookie, bound to the browser through its CSRF
+// token, until the provider redirects to Callback.
+func Login(ctx *server.Context) error {
+	p, err := provider(ctx)
 	if err != nil {
-		return New500HTTPError(err)
+		return err
 	}
-	if err := ctx.DB.SaveUser(user); err != nil {
+	state, err := newOAuthState(p.Name(), ctx.CSRFToken(), redirectTarget(ctx))
+	if err != nil {
 		return New500HTTPError(err)
 	}
-	ctx.User = user
-	if err := ctx.SetUser(); err != nil {
+	if err := state.write(ctx.ResponseWriter); err != nil {
 		return New500HTTPError(err)
 	}
-	ctx.Redirect("/", http.StatusTemporaryRedirect)
+	target := p.AuthCodeURL(state.State,
+		oauth2.S256ChallengeOption(state.Verifier), auth.Nonce(state.Nonce))
+	ctx.Redirect(target, http.StatusTemporaryRedirect)
 	return nil
 }
 
 func Callback(ctx *server.Context) error {
-	// Read oauthState from Cookie
-	oauthState, err := ctx.Cookie(oauthStateCookieName)
+	p, err := provider(ctx)
+	if err != nil {
+		return err
+	}
+	// the state is single use, whatever the outcome of the callback
+	state, err := readOAuthState(ctx.Request)
+	clearOAuthState(ctx.ResponseWriter)
 	if err != nil {
 		return New400HTTPError(err)
 	}
-
-	if ctx.FormValue("state") != oauthState.Value {
-		return New400HTTPError(ErrInvalidState)
+	if err := state.check(p.Name(), ctx.CSRFToken(), ctx.FormValue("state")); err != nil {
+		return New400HTTPError(err)
 	}
-	token, err := github.Exchange(ctx.Context(), ctx.FormValue("code"))
+	if reason := ctx.FormValue("error"); reason != "" {
+		return New400HTTPError(xerrors.New(ErrInvalidCode, reason))
+	}
+	token, err := p.Exchange(ctx.Context(), ctx.FormValue("code"), oauth2.VerifierOption(state.Verifier))
 	if err != nil {
-		return New400HTTPError(ErrInvalidCode)
+		return New400HTTPError(xerrors.WithWrapper(ErrInvalidCode, err))
 	}
-	user, err := github.User(ctx.Context(), token)
+	user, err := p.User(ctx.Context(), token, state.Nonce)
 	if err != nil {
 		return New500HTTPError(err)
 	}
-	if err := ctx.DB.SaveUser(user); err != nil {
+	if err := ctx.DB.UpsertUser(ctx.Context(), user); errors.Is(err, data.ErrEmailTaken) {
+		return New409HTTPError(err)
+	} else if err != nil {
+		return New500HTTPError(err)
+	}
+	if err := ctx.DB.SaveToken(ctx.Context(), user.ID, p.Name(), token); err != nil {
 		return New500HTTPError(err)
 	}
 	ctx.User = user
 	if err := ctx.SetUser(); err != nil {
 		return New500HTTPError(err)
 	}
-	ctx.Logger.Info().Msg("successfully logged client through github oauth")
-	ctx.Redirect("/", http.StatusTemporaryRedirect)
+	ctx.Logger.Info().Str("provider", p.Name()).Msg("successfully logged client through oauth")
+	ctx.Redirect(state.Redirect, http.StatusSeeOther)
 	return nil
 }
 
-func generateStateOauthCookie(ctx *server.Context) (string, error) {
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
+// LegacyCallback forwards to the github callback, OAuth applications
+// registered before providers were pluggable still redirect to /auth/callback.
+func LegacyCallback(ctx *server.Context) error {
+	target := "/auth/" + github.ProviderName + "/callback"
+	if ctx.URL.RawQuery != "" {
+		target += "?" + ctx.URL.RawQuery
+	}
+	ctx.Redirect(target, http.StatusTemporaryRedirect)
+	return nil
+}
+
+// oauthState holds the secrets of a login attempt between Login and
+// Callback.
+type oauthState struct {
+	Provider string    `json:"provider"`
+	State    string    `json:"state"`
+	Verifier string    `json:"verifier"`
+	Nonce    string    `json:"nonce"`
+	CSRF     string    `json:"csrf"`
+	Redirect string    `json:"redirect"`
+	Expires  time.Time `json:"expires"`
+}
+
+func newOAuthState(provider, csrf, redirect string) (*oauthState, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
 	if err != nil {
+		return nil, err
+	}
+	return &oauthState{Provider: provider, State: state, Nonce: nonce,
+		Verifier: oauth2.GenerateVerifier(), CSRF: csrf, Redirect: redirect,
+		Expires: time.Now().Add(durationState)}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
 		return "", xerrors.WithWrapper(ErrCookieGenerate, err)
 	}
-	state := base64.URLEncoding.EncodeToString(b)
-	ctx.SetCookie(oauthStateCookieName, state, durationState)
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newOAuthStateCookie() http.Cookie {
+	// Lax as the callback is a cross site navigation initiated by the provider
+	return http.Cookie{Name: oauthStateCookieName, Path: "/auth/",
+		HttpOnly: true, Secure: server.SecureCookies(), SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (s *oauthState) write(w http.ResponseWriter) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return xerrors.WithWrapper(ErrCookieGenerate, err)
+	}
+	cookie := newOAuthStateCookie()
+	cookie.Value, cookie.MaxAge = string(value), int(durationState.Seconds())
+	if err := server.WriteEncrypted(w, cookie); err != nil {
+		return xerrors.WithWrapper(ErrCookieGenerate, err)
+	}
+	return nil
+}
 
+func readOAuthState(r *http.Request) (*oauthState, error) {
+	value, err := server.ReadEncrypted(r, oauthStateCookieName)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrCookieRetrieval, err)
+	}
+	state := &oauthState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil, xerrors.WithWrapper(ErrCookieRetrieval, err)
+	}
 	return state, nil
 }
+
+func clearOAuthState(w http.ResponseWriter) {
+	cookie := newOAuthStateCookie()
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+}
+
+// check ensures the callback answers the login attempt of this browser.
+func (s *oauthState) check(provider, csrf, state string) error {
+	switch {
+	case time.Now().After(s.Expires):
+		return xerrors.New(ErrInvalidState, "expired")
+	case s.Provider != provider:
+		return xerrors.New(ErrInvalidState, "provider mismatch")
+	case subtle.ConstantTimeCompare([]byte(s.CSRF), []byte(csrf)) != 1:
+		return xerrors.New(ErrInvalidState, "issued to another session")
+	case subtle.ConstantTimeCompare([]byte(s.State), []byte(state)) != 1:
+		return ErrInvalidState
+	}
+	return nil
+}
+
+// redirectTarget returns where to send the user after login, the next query
+// parameter or the referring page, as long as they are local.
+func redirectTarget(ctx *server.Context) string {
+	if next := ctx.URL.Query().Get("next"); isLocalURL(next) {
+		return next
+	}
+	if referer, err := url.Parse(ctx.Referer()); err == nil && referer.Host == ctx.Host &&
+		!strings.HasPrefix(referer.Path, "/auth/") {
+		if target := referer.RequestURI(); isLocalURL(target) {
+			return target
+		}
+	}
+	return "/"
+}
+
+// isLocalURL rejects anything which could lead to another host, including
+// the scheme relative //host and /\host forms browsers accept.
+func isLocalURL(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") ||
+		strings.HasPrefix(target, "/\\") {
+		return false
+	}
+	u, err := url.Parse(target)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
@@ -1,23 +1,35 @@
 package config
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/mdobak/go-xerrors"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/rs/zerolog"
+	"golang.org/x/oauth2"
 
 	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/auth"
 	"github.com/platipy-io/d2s/internal/github"
 	"github.com/platipy-io/d2s/internal/log"
+	"github.com/platipy-io/d2s/internal/starred"
 	"github.com/platipy-io/d2s/internal/telemetry"
+	"github.com/platipy-io/d2s/internal/webhook"
 	"github.com/platipy-io/d2s/server"
+	"github.com/platipy-io/d2s/types"
 )
 
 type (
@@ -27,11 +39,18 @@ type (
 		Configs        Configs `kong:"help='Path to a configuration file (can be repeated)',name='config',sep='none',type='path'" toml:"-"`
 		Host           string  `kong:"help='Host to listen to'"`
 		Port           int     `kong:"help='Port to listen to',default='8080'"`
+		AdminPort      int     `kong:"help='Port serving health, metrics and pprof',default='9090'" toml:"admin-port"`
 		Public         string  `kong:"help='Path to public directory',default='./public'"`
 		Authentication `kong:"-" toml:"authentication"`
 		Logger         `kong:"embed=''" toml:"logger"`
 		Tracer         `kong:"-" toml:"tracer"`
 		Database       `kong:"-" toml:"database"`
+		Cookie         `kong:"embed,prefix='cookie-',envprefix='COOKIE_'" toml:"cookie"`
+		Session        `kong:"-" toml:"session"`
+		Sync           `kong:"-" toml:"sync"`
+		Webhook        `kong:"embed,prefix='webhook-',envprefix='WEBHOOK_'" toml:"webhook"`
+		Shutdown       `kong:"-" toml:"shutdown"`
+		TLS            `kong:"-" toml:"tls"`
 	}
 
 	Configs []string
@@ -51,17 +70,104 @@ type (
 	}
 
 	Database struct {
-		Path string `toml:"path"`
+		Path    string `toml:"path"`
+		Migrate bool   `toml:"migrate"`
+		Backup  Backup `toml:"backup"`
+		// EncryptionKey protects the secrets stored in the database (OAuth
+		// tokens), EncryptionKeys lists the previous ones while rotating.
+		EncryptionKey  string   `toml:"encryption-key"`
+		EncryptionKeys []string `toml:"encryption-keys"`
+	}
+
+	Backup struct {
+		Dir      string   `toml:"dir"`
+		Interval Duration `toml:"interval"`
+		Keep     int      `toml:"keep"`
+	}
+
+	Duration struct {
+		time.Duration
+	}
+
+	// Cookie holds the hex encoded secrets used to sign cookies. The first one
+	// found (flag/env, then file, then list) signs new cookies while the others
+	// are still accepted, which allows rotating keys without logging users out.
+	Cookie struct {
+		Secret     string   `kong:"help='Hex encoded secret used to sign cookies',env='SECRET'" toml:"secret"`
+		SecretFile string   `kong:"help='File holding hex encoded secrets, one per line, newest first',env='SECRET_FILE',type='path'" toml:"secret-file"`
+		Secrets    []string `kong:"-" toml:"secrets"`
+		// Insecure sends the cookies over plain HTTP as well, when there is no
+		// TLS in front of the server.
+		Insecure bool `kong:"help='Send cookies over plain HTTP too',env='INSECURE'" toml:"insecure"`
+	}
+
+	Session struct {
+		TTL           Duration `toml:"ttl"`
+		PurgeInterval Duration `toml:"purge-interval"`
+	}
+
+	// Sync tunes the background copy of the starred repositories.
+	Sync struct {
+		Interval     Duration `toml:"interval"`
+		FullInterval Duration `toml:"full-interval"`
+		PerPage      int      `toml:"per-page"`
+		MaxItems     int      `toml:"max-items"`
+	}
+
+	// Webhook receives the GitHub events on /hooks/github, it is only served
+	// once its secret is configured.
+	Webhook struct {
+		Secret       string   `kong:"help='Secret signing the GitHub webhook deliveries',env='SECRET'" toml:"secret"`
+		ReplayWindow Duration `kong:"-" toml:"replay-window"`
+	}
+
+	// Shutdown tunes the graceful shutdown, on SIGTERM or interrupt.
+	Shutdown struct {
+		// PreStopDelay keeps serving once not ready anymore, it should cover the
+		// time the load balancer takes to notice.
+		PreStopDelay Duration `toml:"pre-stop-delay"`
+	}
+
+	// TLS serves HTTPS without a terminating proxy, the certificate is loaded
+	// again when its files change. H2C serves HTTP/2 over plain HTTP instead,
+	// behind a mesh.
+	TLS struct {
+		Cert           string   `toml:"cert"`
+		Key            string   `toml:"key"`
+		MinVersion     string   `toml:"min-version"`
+		ClientCA       string   `toml:"client-ca"`
+		ReloadInterval Duration `toml:"reload-interval"`
+		H2C            bool     `toml:"h2c"`
 	}
 
 	Authentication struct {
-		BypassToken  string `toml:"bypass-token"`
+		BypassToken string `toml:"bypass-token"`
+		// Redirect, ClientID and ClientSecret configure the github provider, they
+		// predate [authentication.providers.github] which takes precedence.
 		Redirect     string
-		ClientID     string `toml:"client-id"`
-		ClientSecret string `toml:"client-secret"`
+		ClientID     string                  `toml:"client-id"`
+		ClientSecret string                  `toml:"client-secret"`
+		Providers    map[string]AuthProvider `toml:"providers"`
+	}
+
+	AuthProvider struct {
+		// Type is one of github, gitlab, google or oidc, it defaults to the
+		// name of the provider.
+		Type         string   `toml:"type"`
+		Redirect     string   `toml:"redirect"`
+		ClientID     string   `toml:"client-id"`
+		ClientSecret string   `toml:"client-secret"`
+		Issuer       string   `toml:"issuer"`
+		URL          string   `toml:"url"`
+		Scopes       []string `toml:"scopes"`
 	}
 )
 
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
 func (l *Level) Decode(ctx *kong.DecodeContext) (err error) {
 	if l.Level, err = zerolog.ParseLevel(ctx.Scan.Pop().String()); err != nil {
 		return errors.New("invalid level")
@@ -125,26 +231,287 @@ func (c Configuration) NewLogger() zerolog.Logger {
 		With().Timestamp().Logger()
 }
 
+var (
+	ErrCookieSecret        = xerrors.Message("cookie secret is not configured")
+	ErrCookieSecretInvalid = xerrors.Message("cookie secret must be at least 32 hex encoded bytes")
+)
+
+// minCookieSecret is the minimal size of a secret, in bytes.
+const minCookieSecret = 32
+
+func (c Cookie) IsUnset() bool {
+	return c.Secret == "" && c.SecretFile == "" && len(c.Secrets) == 0
+}
+
+// Keys returns the decoded secrets, newest first.
+func (c Cookie) Keys() ([][]byte, error) {
+	var values []string
+	if c.Secret != "" {
+		values = append(values, c.Secret)
+	}
+	if c.SecretFile != "" {
+		content, err := os.ReadFile(c.SecretFile)
+		if err != nil {
+			return nil, xerrors.New(ErrCookieSecret, c.SecretFile, err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				values = append(values, line)
+			}
+		}
+	}
+	values = append(values, c.Secrets...)
+	return decodeKeys(values, ErrCookieSecretInvalid)
+}
+
+// decodeKeys decodes hex encoded secrets of at least minCookieSecret bytes.
+func decodeKeys(values []string, invalid error) ([][]byte, error) {
+	keys := make([][]byte, 0, len(values))
+	for _, value := range values {
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) < minCookieSecret {
+			return nil, invalid
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// InitCookie sets up the cookie store. In dev mode a random secret is
+// generated when none is configured, so sessions do not survive restarts.
 func (c Configuration) InitCookie() error {
-	token, err := hex.DecodeString("13d6b4dff8f84a10851021ec8608f814570d562c92fe6b5ec4c9f595bcb3234b")
+	keys, err := c.Cookie.Keys()
 	if err != nil {
 		return err
 	}
-	server.InitCookieStore(token)
-	return nil
+	if len(keys) == 0 {
+		if !c.Dev {
+			return ErrCookieSecret
+		}
+		key := make([]byte, minCookieSecret)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	server.SetSecureCookies(!c.Cookie.Insecure)
+	return server.InitCookieStore(keys...)
+}
+
+const (
+	DefaultSessionTTL           = 24 * time.Hour
+	DefaultSessionPurgeInterval = time.Hour
+)
+
+func (s Session) Lifetime() time.Duration {
+	if s.TTL.Duration == 0 {
+		return DefaultSessionTTL
+	}
+	return s.TTL.Duration
+}
+
+func (s Session) Interval() time.Duration {
+	if s.PurgeInterval.Duration == 0 {
+		return DefaultSessionPurgeInterval
+	}
+	return s.PurgeInterval.Duration
+}
+
+// sessionStore backs the sessions with the database, the missing rows are
+// reported as server.ErrSessionNotFound.
+type sessionStore struct {
+	*data.DB
+}
+
+// the legacy cookies are only converted when the store implements LegacyStore
+var _ server.LegacyStore = sessionStore{}
+
+func sessionNotFound(err error) error {
+	if errors.Is(err, data.ErrNotFound) {
+		return xerrors.WithWrapper(server.ErrSessionNotFound, err)
+	}
+	return err
+}
+
+func (s sessionStore) GetSession(ctx context.Context, id string) (*types.Session, error) {
+	session, err := s.DB.GetSession(ctx, id)
+	return session, sessionNotFound(err)
+}
+
+func (s sessionStore) GetUserByID(ctx context.Context, id int64) (*types.User, error) {
+	user, err := s.DB.GetUserByID(ctx, id)
+	return user, sessionNotFound(err)
+}
+
+func (s sessionStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	user, err := s.DB.GetUserByEmail(ctx, email)
+	return user, sessionNotFound(err)
+}
+
+func (s sessionStore) GetToken(ctx context.Context, userID int64, provider string) (*oauth2.Token, error) {
+	token, err := s.DB.GetToken(ctx, userID, provider)
+	return token, sessionNotFound(err)
+}
+
+func (s Session) InitSessions(db *data.DB) error {
+	return server.InitSessionStore(sessionStore{db}, s.Lifetime())
 }
 
-var ErrBypass = xerrors.Message("bypass can only be used with dev mode")
+// Opts returns the syncer options, unset values keep the syncer defaults.
+func (s Sync) Opts() (opts []starred.SyncerOption) {
+	if s.Interval.Duration > 0 {
+		opts = append(opts, starred.WithInterval(s.Interval.Duration))
+	}
+	if s.FullInterval.Duration > 0 {
+		opts = append(opts, starred.WithFullInterval(s.FullInterval.Duration))
+	}
+	if s.PerPage > 0 {
+		opts = append(opts, starred.WithPerPage(s.PerPage))
+	}
+	if s.MaxItems > 0 {
+		opts = append(opts, starred.WithMaxItems(s.MaxItems))
+	}
+	return opts
+}
+
+// Opts returns the receiver options, unset values keep the receiver defaults.
+func (w Webhook) Opts() (opts []webhook.ReceiverOption) {
+	if w.ReplayWindow.Duration > 0 {
+		opts = append(opts, webhook.WithReplayWindow(w.ReplayWindow.Duration))
+	}
+	return opts
+}
+
+// Opts returns the server options of the shutdown.
+func (s Shutdown) Opts() []server.ServerOption {
+	return []server.ServerOption{server.WithPreStopDelay(s.PreStopDelay.Duration)}
+}
+
+var (
+	ErrTLSKeyPair    = xerrors.Message("tls needs both a certificate and a key")
+	ErrTLSMinVersion = xerrors.Message("unknown tls version, expected 1.2 or 1.3")
+	ErrTLSClientCA   = xerrors.Message("no certificate found in tls client CA")
+)
+
+// tlsVersions are the versions TLS can be restricted to, older ones are
+// deprecated.
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// Opts returns the server options serving TLS or h2c, none when neither is
+// configured.
+func (t TLS) Opts() ([]server.ServerOption, error) {
+	if t.H2C {
+		return []server.ServerOption{server.WithH2C()}, nil
+	}
+	if t.Cert == "" && t.Key == "" {
+		return nil, nil
+	} else if t.Cert == "" || t.Key == "" {
+		return nil, ErrTLSKeyPair
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, xerrors.New(ErrTLSMinVersion, t.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	// mutual TLS, clients must present a certificate signed by the CA
+	if t.ClientCA != "" {
+		pem, err := os.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, xerrors.New(ErrTLSClientCA, t.ClientCA)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	reloader, err := server.NewCertReloader(t.Cert, t.Key, t.ReloadInterval.Duration)
+	if err != nil {
+		return nil, err
+	}
+	return []server.ServerOption{server.WithTLS(config), server.WithCertReloader(reloader)}, nil
+}
+
+var (
+	ErrBypass       = xerrors.Message("bypass can only be used with dev mode")
+	ErrNoProvider   = xerrors.Message("no authentication provider configured")
+	ErrProviderType = xerrors.Message("unknown authentication provider type")
+)
+
+func (p AuthProvider) New(ctx context.Context, name string) (auth.Provider, error) {
+	kind := p.Type
+	if kind == "" {
+		kind = name
+	}
+	var provider auth.Provider
+	var err error
+	switch kind {
+	case github.ProviderName:
+		provider, err = github.NewProvider(p.Redirect, p.ClientID, p.ClientSecret)
+	case "gitlab":
+		provider, err = auth.NewGitLab(name, p.URL, p.Redirect, p.ClientID, p.ClientSecret)
+	case "google":
+		provider, err = auth.NewGoogle(ctx, name, p.Redirect, p.ClientID, p.ClientSecret)
+	case "oidc":
+		provider, err = auth.NewOIDC(ctx, name, p.Issuer, p.Redirect, p.ClientID, p.ClientSecret, p.Scopes)
+	default:
+		return nil, xerrors.New(ErrProviderType, name, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// NewProviders instantiates the configured identity providers. In dev mode
+// the bypass token replaces the github provider.
+func (c Configuration) NewProviders(ctx context.Context) (*auth.Registry, error) {
+	providers := map[string]AuthProvider{}
+	for name, provider := range c.Providers {
+		providers[name] = provider
+	}
+	if _, ok := providers[github.ProviderName]; !ok && c.ClientID != "" {
+		providers[github.ProviderName] = AuthProvider{Redirect: c.Redirect,
+			ClientID: c.ClientID, ClientSecret: c.ClientSecret}
+	}
+
+	var list []auth.Provider
+	if c.BypassToken != "" {
+		if !c.Dev {
+			return nil, ErrBypass
+		}
+		bypass, err := github.NewBypass(c.BypassToken, "/auth/github/callback")
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, bypass)
+		delete(providers, github.ProviderName)
+	}
 
-func (c Configuration) InitOAuth() error {
-	if c.Authentication.BypassToken != "" {
-		if c.Dev {
-			return github.InitBypass(c.BypassToken)
-		} else {
-			return ErrBypass
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var err error
+	for _, name := range names {
+		provider, perr := providers[name].New(ctx, name)
+		if perr != nil {
+			err = xerrors.Append(err, perr)
+			continue
 		}
+		list = append(list, provider)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return github.InitOAuth(c.Redirect, c.ClientID, c.ClientSecret)
+	if len(list) == 0 {
+		return nil, ErrNoProvider
+	}
+	return auth.NewRegistry(list...)
 }
 
 func (c *Configuration) ParseFile(path string) error {
@@ -163,6 +530,63 @@ func (c Configuration) IsBypassAuth() bool {
 	return bool(c.Dev) && c.Authentication.BypassToken != ""
 }
 
-func (d Database) NewClient() (*data.DB, error) {
-	return data.NewDB(d.Path)
+func (d Database) NewClient(opts ...data.DBOption) (*data.DB, error) {
+	return data.NewDB(d.Path, opts...)
+}
+
+var (
+	ErrEncryptionKey        = xerrors.Message("database encryption key is not configured")
+	ErrEncryptionKeyInvalid = xerrors.Message("database encryption key must be at least 32 hex encoded bytes")
+)
+
+// EncryptionKeys returns the decoded database keys, newest first. In dev mode
+// a random key is generated when none is configured, stored tokens are then
+// unreadable after a restart and users have to log in again.
+func (c Configuration) EncryptionKeys() ([][]byte, error) {
+	values := c.Database.EncryptionKeys
+	if c.Database.EncryptionKey != "" {
+		values = append([]string{c.Database.EncryptionKey}, values...)
+	}
+	keys, err := decodeKeys(values, ErrEncryptionKeyInvalid)
+	if err != nil || len(keys) != 0 {
+		return keys, err
+	}
+	if !c.Dev {
+		return nil, ErrEncryptionKey
+	}
+	key := make([]byte, minCookieSecret)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return [][]byte{key}, nil
+}
+
+// DefaultBackupKeep is the number of snapshots kept when none is configured, a
+// negative keep disables the rotation and keeps every snapshot.
+const DefaultBackupKeep = 7
+
+func (d Database) NewBackups(db *data.DB) *data.Backups {
+	dir, keep := d.Backup.Dir, d.Backup.Keep
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(d.Path), "backups")
+	}
+	if keep == 0 {
+		keep = DefaultBackupKeep
+	}
+	return data.NewBackups(db, dir, keep)
+}
+
+var ErrDatabasePath = xerrors.Message("database path is not configured")
+
+func (d Database) Restore(ctx context.Context, snapshot string) error {
+	if d.Path == "" {
+		return ErrDatabasePath
+	}
+	return data.Restore(ctx, snapshot, d.Path)
+}
+
+// AutoMigrate reports whether pending migrations should be applied at startup
+// instead of refusing to start.
+func (c Configuration) AutoMigrate() bool {
+	return bool(c.Dev) || c.Database.Migrate
 }
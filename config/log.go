@@ -10,11 +10,18 @@ func (c Configuration) MarshalZerologObject(e *zerolog.Event) {
 	e.Bool("dev", bool(c.Dev))
 	e.Str("host", c.Host)
 	e.Int("port", c.Port)
+	e.Int("admin-port", c.AdminPort)
 
 	e.Object("logger", c.Logger)
 	e.Object("tracer", c.Tracer)
 	e.Object("authentication", c.Authentication)
 	e.Object("database", c.Database)
+	e.Object("cookie", c.Cookie)
+	e.Object("session", c.Session)
+	e.Object("sync", c.Sync)
+	e.Object("webhook", c.Webhook)
+	e.Object("shutdown", c.Shutdown)
+	e.Object("tls", c.TLS)
 }
 
 func (l Logger) MarshalZerologObject(e *zerolog.Event) {
@@ -54,8 +61,90 @@ func (a Authentication) MarshalZerologObject(e *zerolog.Event) {
 	} else {
 		e.Str("bypass-token", "<unset>")
 	}
+	if len(a.Providers) != 0 {
+		dict := zerolog.Dict()
+		for name, provider := range a.Providers {
+			dict.Object(name, provider)
+		}
+		e.Dict("providers", dict)
+	}
+}
+
+func (p AuthProvider) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("type", p.Type)
+	e.Str("redirect", p.Redirect)
+	e.Str("client-id", p.ClientID)
+	if p.ClientSecret != "" {
+		e.Str("client-secret", "*****")
+	} else {
+		e.Str("client-secret", "<unset>")
+	}
+	if p.Issuer != "" {
+		e.Str("issuer", p.Issuer)
+	}
+	if p.URL != "" {
+		e.Str("url", p.URL)
+	}
 }
 
 func (d Database) MarshalZerologObject(e *zerolog.Event) {
 	e.Str("path", d.Path)
+	e.Bool("migrate", d.Migrate)
+	e.Object("backup", d.Backup)
+	if d.EncryptionKey != "" {
+		e.Str("encryption-key", "*****")
+	} else {
+		e.Str("encryption-key", "<unset>")
+	}
+	e.Int("encryption-keys", len(d.EncryptionKeys))
+}
+
+func (b Backup) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("dir", b.Dir)
+	e.Dur("interval", b.Interval.Duration)
+	e.Int("keep", b.Keep)
+}
+
+func (c Cookie) MarshalZerologObject(e *zerolog.Event) {
+	if c.Secret != "" {
+		e.Str("secret", "*****")
+	} else {
+		e.Str("secret", "<unset>")
+	}
+	e.Str("secret-file", c.SecretFile)
+	e.Int("secrets", len(c.Secrets))
+}
+
+func (s Session) MarshalZerologObject(e *zerolog.Event) {
+	e.Dur("ttl", s.Lifetime())
+	e.Dur("purge-interval", s.Interval())
+}
+
+func (s Sync) MarshalZerologObject(e *zerolog.Event) {
+	e.Dur("interval", s.Interval.Duration)
+	e.Dur("full-interval", s.FullInterval.Duration)
+	e.Int("per-page", s.PerPage)
+	e.Int("max-items", s.MaxItems)
+}
+
+func (w Webhook) MarshalZerologObject(e *zerolog.Event) {
+	if w.Secret != "" {
+		e.Str("secret", "*****")
+	} else {
+		e.Str("secret", "<unset>")
+	}
+	e.Dur("replay-window", w.ReplayWindow.Duration)
+}
+
+func (s Shutdown) MarshalZerologObject(e *zerolog.Event) {
+	e.Dur("pre-stop-delay", s.PreStopDelay.Duration)
+}
+
+func (t TLS) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("cert", t.Cert)
+	e.Str("key", t.Key)
+	e.Str("min-version", t.MinVersion)
+	e.Str("client-ca", t.ClientCA)
+	e.Dur("reload-interval", t.ReloadInterval.Duration)
+	e.Bool("h2c", t.H2C)
 }
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS `idx_email__users`;
+
+DROP TABLE users;
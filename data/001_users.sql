@@ -1,4 +1,4 @@
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
 	-- https://stackoverflow.com/questions/7905859/is-there-auto-increment-in-sqlite#answer-7905936
 	id INTEGER PRIMARY KEY,
 	email TEXT DEFAULT '' NOT NULL,
@@ -7,4 +7,4 @@ CREATE TABLE users (
 	UNIQUE(email)
 );
 
-CREATE UNIQUE INDEX `idx_email__users` ON `users` (`email`) WHERE `email` != '';
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_email__users` ON `users` (`email`) WHERE `email` != '';
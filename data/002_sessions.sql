@@ -0,0 +1,11 @@
+CREATE TABLE sessions (
+	-- sha256 of the identifier held by the client cookie
+	id TEXT PRIMARY KEY,
+	owner TEXT NOT NULL,
+	data BLOB NOT NULL,
+	created DATETIME NOT NULL,
+	expires DATETIME NOT NULL
+);
+
+CREATE INDEX `idx_owner__sessions` ON `sessions` (`owner`);
+CREATE INDEX `idx_expires__sessions` ON `sessions` (`expires`);
@@ -0,0 +1,26 @@
+DROP TABLE sessions;
+CREATE TABLE sessions (
+	-- sha256 of the identifier held by the client cookie
+	id TEXT PRIMARY KEY,
+	owner TEXT NOT NULL,
+	data BLOB NOT NULL,
+	created DATETIME NOT NULL,
+	expires DATETIME NOT NULL
+);
+
+CREATE INDEX `idx_owner__sessions` ON `sessions` (`owner`);
+CREATE INDEX `idx_expires__sessions` ON `sessions` (`expires`);
+
+CREATE TABLE users_old (
+	id INTEGER PRIMARY KEY,
+	email TEXT DEFAULT '' NOT NULL,
+	name TEXT DEFAULT '' NOT NULL,
+	created DATETIME,
+	UNIQUE(email)
+);
+
+INSERT INTO users_old (id, email, name, created) SELECT id, email, name, created FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE UNIQUE INDEX `idx_email__users` ON `users` (`email`) WHERE `email` != '';
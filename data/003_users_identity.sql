@@ -0,0 +1,36 @@
+-- SQLite can't drop a table constraint, the users table is rebuilt to replace
+-- UNIQUE(email) (which forbids several users without email) by the partial
+-- index, and to link users to their identity provider.
+CREATE TABLE users_new (
+	id INTEGER PRIMARY KEY,
+	email TEXT DEFAULT '' NOT NULL,
+	name TEXT DEFAULT '' NOT NULL,
+	avatar TEXT DEFAULT '' NOT NULL,
+	provider TEXT DEFAULT '' NOT NULL,
+	-- identifier of the user at the provider, stable across email changes
+	subject TEXT DEFAULT '' NOT NULL,
+	created DATETIME,
+	last_login DATETIME
+);
+
+INSERT INTO users_new (id, email, name, created) SELECT id, email, name, created FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE UNIQUE INDEX `idx_email__users` ON `users` (`email`) WHERE `email` != '';
+CREATE UNIQUE INDEX `idx_provider_subject__users` ON `users` (`provider`, `subject`) WHERE `subject` != '';
+
+-- sessions were owned by an email, they now reference the user row. Existing
+-- sessions can't be mapped reliably, their users have to log in again.
+DROP TABLE sessions;
+CREATE TABLE sessions (
+	-- sha256 of the identifier held by the client cookie
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	data BLOB NOT NULL,
+	created DATETIME NOT NULL,
+	expires DATETIME NOT NULL
+);
+
+CREATE INDEX `idx_user_id__sessions` ON `sessions` (`user_id`);
+CREATE INDEX `idx_expires__sessions` ON `sessions` (`expires`);
@@ -0,0 +1,9 @@
+CREATE TABLE tokens (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	provider TEXT NOT NULL,
+	-- JSON encoded oauth2 token (access, refresh and expiry), encrypted with
+	-- the application key
+	data BLOB NOT NULL,
+	updated DATETIME NOT NULL,
+	PRIMARY KEY (user_id, provider)
+);
@@ -0,0 +1,10 @@
+-- custom ordering of the starred repositories of each user, repository_id is
+-- the identifier given by the provider
+CREATE TABLE user_repositories (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	repository_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (user_id, repository_id)
+);
+
+CREATE INDEX `idx_user_id_position__user_repositories` ON `user_repositories` (`user_id`, `position`);
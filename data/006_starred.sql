@@ -0,0 +1,23 @@
+-- local copy of the starred repositories of each user, rank is the position
+-- in the listing of the provider (most recently starred first)
+CREATE TABLE repositories (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	id INTEGER NOT NULL,
+	owner TEXT DEFAULT '' NOT NULL,
+	name TEXT DEFAULT '' NOT NULL,
+	description TEXT DEFAULT '' NOT NULL,
+	language TEXT DEFAULT '' NOT NULL,
+	updated DATETIME,
+	rank INTEGER NOT NULL,
+	PRIMARY KEY (user_id, id)
+);
+
+CREATE INDEX `idx_user_id_rank__repositories` ON `repositories` (`user_id`, `rank`);
+
+CREATE TABLE sync_state (
+	user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	etag TEXT DEFAULT '' NOT NULL,
+	checked DATETIME,
+	synced DATETIME,
+	error TEXT DEFAULT '' NOT NULL
+);
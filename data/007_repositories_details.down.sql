@@ -0,0 +1,7 @@
+ALTER TABLE repositories DROP COLUMN fork_of;
+ALTER TABLE repositories DROP COLUMN fork;
+ALTER TABLE repositories DROP COLUMN archived;
+ALTER TABLE repositories DROP COLUMN topics;
+ALTER TABLE repositories DROP COLUMN forks;
+ALTER TABLE repositories DROP COLUMN stars;
+ALTER TABLE repositories DROP COLUMN html_url;
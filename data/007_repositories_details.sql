@@ -0,0 +1,12 @@
+-- details of the starred repositories, topics is a JSON array and fork_of the
+-- full name of the parent when the provider reports it
+ALTER TABLE repositories ADD COLUMN html_url TEXT DEFAULT '' NOT NULL;
+ALTER TABLE repositories ADD COLUMN stars INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE repositories ADD COLUMN forks INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE repositories ADD COLUMN topics TEXT DEFAULT '[]' NOT NULL;
+ALTER TABLE repositories ADD COLUMN archived BOOLEAN DEFAULT FALSE NOT NULL;
+ALTER TABLE repositories ADD COLUMN fork BOOLEAN DEFAULT FALSE NOT NULL;
+ALTER TABLE repositories ADD COLUMN fork_of TEXT DEFAULT '' NOT NULL;
+
+-- the copy predates these columns, fetch it in full on the next sync
+UPDATE sync_state SET etag = '';
@@ -0,0 +1,2 @@
+DROP TABLE collection_repositories;
+DROP TABLE collections;
@@ -0,0 +1,21 @@
+-- named groups of starred repositories, share_token is empty unless the
+-- collection is readable by anyone holding the link
+CREATE TABLE collections (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	share_token TEXT DEFAULT '' NOT NULL,
+	created DATETIME NOT NULL,
+	UNIQUE (user_id, name)
+);
+
+CREATE UNIQUE INDEX `idx_share_token__collections` ON `collections` (`share_token`) WHERE `share_token` != '';
+
+-- repositories of each collection, repository_id is the identifier given by
+-- the provider
+CREATE TABLE collection_repositories (
+	collection_id INTEGER NOT NULL REFERENCES collections (id) ON DELETE CASCADE,
+	repository_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (collection_id, repository_id)
+);
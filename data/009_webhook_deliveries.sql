@@ -0,0 +1,8 @@
+-- deliveries of webhooks already received, kept for a while so replays of a
+-- signed payload are rejected
+CREATE TABLE webhook_deliveries (
+	id TEXT PRIMARY KEY,
+	received DATETIME NOT NULL
+);
+
+CREATE INDEX `idx_received__webhook_deliveries` ON `webhook_deliveries` (`received`);
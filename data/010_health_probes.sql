@@ -0,0 +1,6 @@
+-- single row rewritten by the health check, writing catches a read-only or
+-- full disk which reads would not notice
+CREATE TABLE health_probes (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	checked DATETIME NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE repositories ADD COLUMN fork_of TEXT DEFAULT '' NOT NULL;
@@ -0,0 +1,3 @@
+-- listings and events never report the parent of forks, the column was always
+-- empty
+ALTER TABLE repositories DROP COLUMN fork_of;
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/internal/log"
+)
+
+const (
+	backupPrefix = "backup-"
+	backupSuffix = ".db"
+	// fixed width fractions keep the names sorting chronologically and two
+	// snapshots taken within the same second from colliding
+	backupLayout = "20060102T150405.000000Z"
+)
+
+var (
+	ErrBackup    = xerrors.Message("backup failed")
+	ErrRestore   = xerrors.Message("restore failed")
+	ErrIntegrity = xerrors.Message("integrity check failed")
+)
+
+// Backup writes a consistent snapshot of the database to path. It relies on
+// VACUUM INTO which reads from a single transaction, so it is safe to run
+// while the server keeps serving requests.
+func (c *DB) Backup(ctx context.Context, path string) error {
+	if _, err := c.db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return xerrors.New(ErrBackup, path, err)
+	}
+	return nil
+}
+
+// Verify opens the database at path in read only mode and runs an integrity
+// check on it.
+func Verify(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return xerrors.WithWrapper(ErrIntegrity, err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return xerrors.WithWrapper(ErrIntegrity, err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return xerrors.WithWrapper(ErrIntegrity, err)
+	}
+	defer rows.Close()
+	var msgs []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return xerrors.WithWrapper(ErrIntegrity, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return xerrors.WithWrapper(ErrIntegrity, err)
+	}
+	if len(msgs) != 1 || msgs[0] != "ok" {
+		return xerrors.New(ErrIntegrity, path, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Restore replaces the database at path with the given snapshot once it has
+// been verified. The server must not be running while restoring.
+func Restore(ctx context.Context, snapshot, path string) error {
+	if err := Verify(ctx, snapshot); err != nil {
+		return xerrors.WithWrapper(ErrRestore, err)
+	}
+	src, err := os.Open(snapshot)
+	if err != nil {
+		return xerrors.WithWrapper(ErrRestore, err)
+	}
+	defer src.Close()
+
+	// copy next to the destination first so the final rename is atomic
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return xerrors.WithWrapper(ErrRestore, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return xerrors.WithWrapper(ErrRestore, err)
+	}
+	if err := xerrors.Append(tmp.Sync(), tmp.Close()); err != nil {
+		return xerrors.WithWrapper(ErrRestore, err)
+	}
+	// stale journals would be replayed on top of the restored file
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return xerrors.WithWrapper(ErrRestore, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return xerrors.WithWrapper(ErrRestore, err)
+	}
+	return nil
+}
+
+// Backups manages a directory of timestamped snapshots of a database.
+type Backups struct {
+	db   *DB
+	dir  string
+	keep int
+}
+
+// NewBackups stores snapshots of db in dir, keeping only the keep most recent
+// ones (all of them when keep is 0 or less).
+func NewBackups(db *DB, dir string, keep int) *Backups {
+	return &Backups{db: db, dir: dir, keep: keep}
+}
+
+// List returns the path of the snapshots, most recent first.
+func (b *Backups) List() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) &&
+			strings.HasSuffix(name, backupSuffix) {
+			out = append(out, filepath.Join(b.dir, name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(out)))
+	return out, nil
+}
+
+// Run takes a new snapshot, verifies it and removes the oldest ones.
+func (b *Backups) Run(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(b.dir, 0o750); err != nil {
+		return "", xerrors.WithWrapper(ErrBackup, err)
+	}
+	name := backupPrefix + time.Now().UTC().Format(backupLayout) + backupSuffix
+	path := filepath.Join(b.dir, name)
+	if err := b.db.Backup(ctx, path); err != nil {
+		return "", err
+	}
+	if err := Verify(ctx, path); err != nil {
+		os.Remove(path)
+		return "", xerrors.WithWrapper(ErrBackup, err)
+	}
+	return path, b.rotate()
+}
+
+func (b *Backups) rotate() (err error) {
+	if b.keep <= 0 {
+		return nil
+	}
+	backups, err := b.List()
+	if err != nil {
+		return xerrors.WithWrapper(ErrBackup, err)
+	}
+	for i := b.keep; i < len(backups); i++ {
+		err = xerrors.Append(err, os.Remove(backups[i]))
+	}
+	return err
+}
+
+// Schedule takes a snapshot every interval until the context is done.
+func (b *Backups) Schedule(ctx context.Context, interval time.Duration) {
+	logger := log.Ctx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if path, err := b.Run(ctx); err != nil {
+				logger.Error().Ctx(ctx).Stack().Err(err).Msg("scheduled backup failed")
+			} else {
+				logger.Info().Ctx(ctx).Str("path", path).Msg("database backed up")
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+// newSeededDB migrates a database at path and saves a user owning email.
+func newSeededDB(t *testing.T, path string, email string) *DB {
+	t.Helper()
+	ctx := context.Background()
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertUser(ctx, types.NewUser("user", email)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := newSeededDB(t, filepath.Join(dir, "source.db"), "saved@example.com")
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if err := db.Backup(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// restore over a live database, along with its journals
+	live := filepath.Join(dir, "live.db")
+	liveDB := newSeededDB(t, live, "lost@example.com")
+	if err := liveDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.WriteFile(live+suffix, []byte("stale"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Restore(ctx, snapshot, live); err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if _, err := os.Stat(live + suffix); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", suffix, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".restore-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+
+	restored, err := NewDB(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if _, err := restored.GetUserByEmail(ctx, "saved@example.com"); err != nil {
+		t.Errorf("expected the user of the snapshot, got %v", err)
+	}
+	if _, err := restored.GetUserByEmail(ctx, "lost@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the user of the live database to be gone, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		content []byte
+	}{
+		{"missing", nil},
+		{"not a database", []byte(strings.Repeat("garbage", 1024))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name+".db")
+			if tc.content != nil {
+				if err := os.WriteFile(path, tc.content, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := Verify(ctx, path); !errors.Is(err, ErrIntegrity) {
+				t.Errorf("expected integrity error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRestoreRefusesCorrupt(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.db")
+	newSeededDB(t, live, "kept@example.com").Close()
+	before, err := os.ReadFile(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(snapshot, []byte(strings.Repeat("garbage", 1024)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, snapshot, live); !errors.Is(err, ErrRestore) {
+		t.Fatalf("expected restore error, got %v", err)
+	}
+	if after, err := os.ReadFile(live); err != nil || string(after) != string(before) {
+		t.Errorf("live database changed by a failed restore, %v", err)
+	}
+}
+
+func TestBackupsRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := newSeededDB(t, filepath.Join(dir, "d2s.db"), "user@example.com")
+
+	for _, tc := range []struct {
+		keep, runs, expected int
+	}{
+		{keep: 2, runs: 4, expected: 2},
+		{keep: 0, runs: 3, expected: 3},
+		{keep: -1, runs: 3, expected: 3},
+	} {
+		backups := NewBackups(db, t.TempDir(), tc.keep)
+		var taken []string
+		for i := 0; i < tc.runs; i++ {
+			path, err := backups.Run(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			taken = append(taken, path)
+		}
+		list, err := backups.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != tc.expected {
+			t.Fatalf("keep %d: expected %d snapshots, got %v", tc.keep, tc.expected, list)
+		}
+		// most recent first, the names sort chronologically
+		for i, path := range list {
+			if expected := taken[len(taken)-1-i]; path != expected {
+				t.Errorf("keep %d: expected %s at %d, got %s", tc.keep, expected, i, path)
+			}
+		}
+	}
+}
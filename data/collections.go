@@ -0,0 +1,262 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/bokwoon95/sq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/mdobak/go-xerrors"
+	"github.com/platipy-io/d2s/types"
+)
+
+var (
+	collections            = sq.New[COLLECTIONS]("")
+	collectionRepositories = sq.New[COLLECTION_REPOSITORIES]("")
+)
+
+var ErrDuplicate = xerrors.Message("already exists")
+
+// shareTokenSize is the number of random bytes of a share token.
+const shareTokenSize = 18
+
+func collectionRow(row *sq.Row) *types.Collection {
+	return &types.Collection{
+		ID:         row.Int64Field(collections.ID),
+		UserID:     row.Int64Field(collections.USER_ID),
+		Name:       row.StringField(collections.NAME),
+		ShareToken: row.StringField(collections.SHARE_TOKEN),
+		Created:    row.TimeField(collections.CREATED),
+		Count: row.Int("(SELECT COUNT(*) FROM collection_repositories WHERE {} = {})",
+			collectionRepositories.COLLECTION_ID, collections.ID),
+	}
+}
+
+// Collections returns the collections of the user, sorted by name.
+func (c *DB) Collections(ctx context.Context, userID int64) ([]*types.Collection, error) {
+	return sq.FetchAllContext(ctx, c.db, sq.
+		From(collections).
+		Where(collections.USER_ID.EqInt64(userID)).
+		OrderBy(sq.Expr("{} COLLATE NOCASE", collections.NAME)).
+		SetDialect(sq.DialectSQLite),
+		collectionRow)
+}
+
+// Collection returns the collection of the user matching id, ErrNotFound is
+// returned if it does not exist or belongs to another user.
+func (c *DB) Collection(ctx context.Context, userID, id int64) (*types.Collection, error) {
+	return getCollection(ctx, c.db, collections.ID.EqInt64(id), collections.USER_ID.EqInt64(userID))
+}
+
+// SharedCollection returns the collection shared with token, ErrNotFound is
+// returned if no collection is shared with it.
+func (c *DB) SharedCollection(ctx context.Context, token string) (*types.Collection, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	return getCollection(ctx, c.db, collections.SHARE_TOKEN.EqString(token))
+}
+
+func getCollection(ctx context.Context, db sq.DB, predicates ...sq.Predicate) (*types.Collection, error) {
+	collection, err := sq.FetchOneContext(ctx, db, sq.
+		From(collections).
+		Where(predicates...).
+		SetDialect(sq.DialectSQLite),
+		collectionRow)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return collection, err
+}
+
+// CreateCollection stores a new collection and sets its ID, ErrDuplicate is
+// returned if the user already has a collection with this name.
+func (c *DB) CreateCollection(ctx context.Context, collection *types.Collection) error {
+	if collection.Created.IsZero() {
+		collection.Created = time.Now()
+	}
+	result, err := sq.ExecContext(ctx, c.db, sq.
+		InsertInto(collections).
+		Columns(collections.USER_ID, collections.NAME, collections.SHARE_TOKEN, collections.CREATED).
+		Values(collection.UserID, collection.Name, collection.ShareToken, collection.Created.UTC()).
+		SetDialect(sq.DialectSQLite))
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return xerrors.New(ErrDuplicate, "collection", collection.Name)
+	} else if err != nil {
+		return err
+	}
+	collection.ID = result.LastInsertId
+	return nil
+}
+
+// DeleteCollection removes the collection of the user matching id along with
+// its repositories, ErrNotFound is returned if there is none.
+func (c *DB) DeleteCollection(ctx context.Context, userID, id int64) error {
+	result, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(collections).
+		Where(collections.ID.EqInt64(id), collections.USER_ID.EqInt64(userID)).
+		SetDialect(sq.DialectSQLite))
+	if err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ShareCollection generates a new share token for the collection of the user
+// matching id, or removes it when share is false. The token is returned,
+// empty when the collection is not shared anymore.
+func (c *DB) ShareCollection(ctx context.Context, userID, id int64, share bool) (string, error) {
+	var token string
+	if share {
+		value := make([]byte, shareTokenSize)
+		if _, err := rand.Read(value); err != nil {
+			return "", err
+		}
+		token = base64.RawURLEncoding.EncodeToString(value)
+	}
+	result, err := sq.ExecContext(ctx, c.db, sq.
+		Update(collections).
+		Set(collections.SHARE_TOKEN.SetString(token)).
+		Where(collections.ID.EqInt64(id), collections.USER_ID.EqInt64(userID)).
+		SetDialect(sq.DialectSQLite))
+	if err != nil {
+		return "", err
+	} else if result.RowsAffected == 0 {
+		return "", ErrNotFound
+	}
+	return token, nil
+}
+
+// CollectionRepositories returns the repositories of the collection found in
+// the local copy of its owner, in the order of the collection. Repositories
+// which are not starred anymore are left out.
+func (c *DB) CollectionRepositories(ctx context.Context, collection *types.Collection) ([]*types.Repository, error) {
+	return sq.FetchAllContext(ctx, c.db, sq.
+		From(collectionRepositories).
+		Join(repositories,
+			repositories.USER_ID.EqInt64(collection.UserID),
+			repositories.ID.Eq(collectionRepositories.REPOSITORY_ID)).
+		Where(collectionRepositories.COLLECTION_ID.EqInt64(collection.ID)).
+		OrderBy(collectionRepositories.POSITION).
+		SetDialect(sq.DialectSQLite),
+		repositoryRow)
+}
+
+// AddToCollection appends the repository to the collection of the user, it is
+// a no-op when the repository is already part of it. ErrNotFound is returned
+// if the collection does not belong to the user or the repository is not in
+// their starred copy.
+func (c *DB) AddToCollection(ctx context.Context, userID, id, repoID int64) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := getCollection(ctx, tx, collections.ID.EqInt64(id), collections.USER_ID.EqInt64(userID)); err != nil {
+			return err
+		}
+		starred, err := sq.FetchExistsContext(ctx, tx, sq.
+			Select(sq.Expr("1")).
+			From(repositories).
+			Where(repositories.USER_ID.EqInt64(userID), repositories.ID.EqInt64(repoID)).
+			SetDialect(sq.DialectSQLite))
+		if err != nil {
+			return err
+		} else if !starred {
+			return xerrors.New(ErrNotFound, "repository", repoID)
+		}
+		position, err := sq.FetchOneContext(ctx, tx, sq.
+			From(collectionRepositories).
+			Where(collectionRepositories.COLLECTION_ID.EqInt64(id)).
+			SetDialect(sq.DialectSQLite),
+			func(row *sq.Row) int {
+				return row.Int("COALESCE(MAX({}) + 1, 0)", collectionRepositories.POSITION)
+			})
+		if err != nil {
+			return err
+		}
+		_, err = sq.ExecContext(ctx, tx, sq.SQLite.
+			InsertInto(collectionRepositories).
+			Columns(collectionRepositories.COLLECTION_ID, collectionRepositories.REPOSITORY_ID,
+				collectionRepositories.POSITION).
+			Values(id, repoID, position).
+			OnConflict(collectionRepositories.COLLECTION_ID, collectionRepositories.REPOSITORY_ID).
+			DoNothing())
+		return err
+	})
+}
+
+// RemoveFromCollection takes the repository out of the collection of the
+// user, ErrNotFound is returned if it was not part of it.
+func (c *DB) RemoveFromCollection(ctx context.Context, userID, id, repoID int64) error {
+	owned := sq.
+		Select(sq.Expr("1")).
+		From(collections).
+		Where(collections.ID.Eq(collectionRepositories.COLLECTION_ID), collections.USER_ID.EqInt64(userID))
+	result, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(collectionRepositories).
+		Where(
+			collectionRepositories.COLLECTION_ID.EqInt64(id),
+			collectionRepositories.REPOSITORY_ID.EqInt64(repoID),
+			sq.Exists(owned),
+		).
+		SetDialect(sq.DialectSQLite))
+	if err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SaveCollectionOrder reorders the collection of the user with ids, first
+// one on top. Identifiers which are not part of the collection are ignored,
+// the repositories which were not posted keep their order after them.
+func (c *DB) SaveCollectionOrder(ctx context.Context, userID, id int64, ids []int64) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := getCollection(ctx, tx, collections.ID.EqInt64(id), collections.USER_ID.EqInt64(userID)); err != nil {
+			return err
+		}
+		current, err := sq.FetchAllContext(ctx, tx, sq.
+			From(collectionRepositories).
+			Where(collectionRepositories.COLLECTION_ID.EqInt64(id)).
+			OrderBy(collectionRepositories.POSITION).
+			SetDialect(sq.DialectSQLite),
+			func(row *sq.Row) int64 { return row.Int64Field(collectionRepositories.REPOSITORY_ID) })
+		if err != nil || len(current) == 0 {
+			return err
+		}
+		members := make(map[int64]bool, len(current))
+		for _, repoID := range current {
+			members[repoID] = true
+		}
+		order := make([]int64, 0, len(current))
+		for _, repoID := range slices.Concat(ids, current) {
+			if members[repoID] {
+				order, members[repoID] = append(order, repoID), false
+			}
+		}
+		_, err = sq.ExecContext(ctx, tx, sq.
+			DeleteFrom(collectionRepositories).
+			Where(collectionRepositories.COLLECTION_ID.EqInt64(id)).
+			SetDialect(sq.DialectSQLite))
+		if err != nil {
+			return err
+		}
+		_, err = sq.ExecContext(ctx, tx, sq.
+			InsertInto(collectionRepositories).
+			ColumnValues(func(col *sq.Column) {
+				for i, repoID := range order {
+					col.SetInt64(collectionRepositories.COLLECTION_ID, id)
+					col.SetInt64(collectionRepositories.REPOSITORY_ID, repoID)
+					col.SetInt(collectionRepositories.POSITION, i)
+				}
+			}).
+			SetDialect(sq.DialectSQLite))
+		return err
+	})
+}
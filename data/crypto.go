@@ -0,0 +1,38 @@
+package data
+
+import "github.com/mdobak/go-xerrors"
+
+var (
+	ErrNoEncryptionKey = xerrors.Message("no encryption key configured")
+	ErrEncrypt         = xerrors.Message("failed encrypting value")
+	ErrDecrypt         = xerrors.Message("failed decrypting value")
+)
+
+// encryptionInfo derives the keys of the database apart from the other usages
+// of the same secrets.
+const encryptionInfo = "d2s data encryption"
+
+// encrypt seals plaintext with the newest key. The label binds the value to
+// the row it is stored in.
+func (c *DB) encrypt(label string, plaintext []byte) ([]byte, error) {
+	if c.keyring == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	encrypted, err := c.keyring.Seal(label, plaintext)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrEncrypt, err)
+	}
+	return encrypted, nil
+}
+
+// decrypt opens a value produced by encrypt, trying each known key.
+func (c *DB) decrypt(label string, encrypted []byte) ([]byte, error) {
+	if c.keyring == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	plaintext, err := c.keyring.Open(label, encrypted)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
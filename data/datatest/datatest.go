@@ -0,0 +1,51 @@
+// Package datatest sets up databases for the tests of the packages storing
+// their state with data.
+package datatest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/types"
+)
+
+// Subject is how GitHub knows the user saved by NewStore.
+const Subject = "1234"
+
+// NewStore returns a migrated database in a temporary directory, closed at
+// the end of the test, along with the ID of a user logged in with GitHub: the
+// user has a token stored and an open session.
+func NewStore(t testing.TB) (*data.DB, int64) {
+	t.Helper()
+	ctx := context.Background()
+	db, err := data.NewDB(filepath.Join(t.TempDir(), "d2s.db"),
+		data.WithEncryptionKeys(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	user := types.NewUser("user", "user@example.com")
+	user.Provider, user.Subject = github.ProviderName, Subject
+	if err := db.UpsertUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	token := &oauth2.Token{AccessToken: "access", TokenType: "Bearer"}
+	if err := db.SaveToken(ctx, user.ID, github.ProviderName, token); err != nil {
+		t.Fatal(err)
+	}
+	session := &types.Session{ID: "session", UserID: user.ID, Data: []byte{0},
+		Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+	if err := db.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	return db, user.ID
+}
@@ -2,15 +2,73 @@ package data
 
 import (
 	"database/sql"
+	"strings"
+	"sync/atomic"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/platipy-io/d2s/internal/aead"
 )
 
 type DB struct {
 	db *sql.DB
+	// keyring encrypts secrets at rest, nil without keys.
+	keyring *aead.Keyring
+	// fullText is set by SetupSearch when the FTS5 index is usable.
+	fullText atomic.Bool
+}
+
+type dbConfig struct {
+	keys [][]byte
+}
+
+// DBOption applies a configuration option value to a DB.
+type DBOption interface {
+	apply(dbConfig) dbConfig
+}
+
+type DBOptionFunc func(dbConfig) dbConfig
+
+func (fn DBOptionFunc) apply(c dbConfig) dbConfig {
+	return fn(c)
+}
+
+// WithEncryptionKeys sets the keys protecting secrets stored in the database,
+// newest first. Older keys are only used to decrypt, which allows rotation.
+func WithEncryptionKeys(keys ...[]byte) DBOption {
+	return DBOptionFunc(func(dc dbConfig) dbConfig {
+		dc.keys = keys
+		return dc
+	})
+}
+
+func NewDB(path string, opts ...DBOption) (*DB, error) {
+	dc := dbConfig{}
+	for _, opt := range opts {
+		dc = opt.apply(dc)
+	}
+	var keyring *aead.Keyring
+	if len(dc.keys) > 0 {
+		var err error
+		if keyring, err = aead.New(encryptionInfo, dc.keys...); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite3", dsn(path))
+	return &DB{db: db, keyring: keyring}, err
+}
+
+// Close closes the database, the queries started before are waited for.
+func (c *DB) Close() error {
+	return c.db.Close()
 }
 
-func NewDB(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", path)
-	return &DB{db: db}, err
+// dsn enables foreign keys, SQLite leaves them off unless asked on every
+// connection.
+func dsn(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_foreign_keys=on"
 }
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/bokwoon95/sq"
+	"github.com/mdobak/go-xerrors"
+)
+
+var healthProbes = sq.New[HEALTH_PROBES]("")
+
+var ErrUnhealthy = xerrors.Message("database unhealthy")
+
+// HealthCheck pings the database then rewrites the probe row, a read-only or
+// full disk fails the write while reads still succeed.
+func (c *DB) HealthCheck(ctx context.Context) error {
+	if err := c.db.PingContext(ctx); err != nil {
+		return xerrors.New(ErrUnhealthy, "ping", err)
+	}
+	now := time.Now().UTC()
+	_, err := sq.ExecContext(ctx, c.db, sq.SQLite.
+		InsertInto(healthProbes).
+		Columns(healthProbes.ID, healthProbes.CHECKED).
+		Values(1, now).
+		OnConflict(healthProbes.ID).
+		DoUpdateSet(healthProbes.CHECKED.SetTime(now)))
+	if err != nil {
+		return xerrors.New(ErrUnhealthy, "write probe", err)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// migrations holds the numbered SQL files shipped with the binary. A migration
+// is made of a NNN_name.sql file applying the change and an optional
+// NNN_name.down.sql file reverting it.
+//
+//go:embed *.sql
+var embedded embed.FS
+
+// migrations is where the migrations are read from, tests swap it.
+var migrations fs.FS = embedded
+
+var migrationRe = regexp.MustCompile(`^(\d+)_(\w+?)(\.down)?\.sql$`)
+
+var (
+	ErrMigration       = xerrors.Message("migration failed")
+	ErrMigrationFile   = xerrors.Message("invalid migration file")
+	ErrNoDownMigration = xerrors.Message("migration can't be reverted")
+	ErrSchemaBehind    = xerrors.Message("database schema is behind, run migrations")
+	ErrSchemaAhead     = xerrors.Message("database schema is ahead of the binary")
+)
+
+type (
+	Migration struct {
+		Version  int
+		Name     string
+		Up, Down string
+	}
+
+	MigrationStatus struct {
+		Migration
+		Applied time.Time
+	}
+)
+
+func (m Migration) String() string { return fmt.Sprintf("%03d_%s", m.Version, m.Name) }
+
+// IsApplied reports whether the migration has been recorded in the database.
+func (ms MigrationStatus) IsApplied() bool { return !ms.Applied.IsZero() }
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrations)
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrMigrationFile, err)
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := migrationRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, xerrors.New(ErrMigrationFile, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, xerrors.WithWrapper(ErrMigrationFile, err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, xerrors.New(ErrMigrationFile, "duplicate version", entry.Name())
+		}
+		if match[3] != "" {
+			m.Down = string(content)
+		} else {
+			m.Up = string(content)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, xerrors.New(ErrMigrationFile, "missing up migration", m.String())
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func (c *DB) initMigrations(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied DATETIME NOT NULL
+)`)
+	return err
+}
+
+func (c *DB) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := c.initMigrations(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryContext(ctx, "SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var applied time.Time
+		if err := rows.Scan(&version, &applied); err != nil {
+			return nil, err
+		}
+		out[version] = applied
+	}
+	return out, rows.Err()
+}
+
+// MigrationStatus lists every known migration along with the time it was
+// applied, if any.
+func (c *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	all, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.applied(ctx)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrMigration, err)
+	}
+	out := make([]MigrationStatus, len(all))
+	for i, m := range all {
+		out[i] = MigrationStatus{Migration: m, Applied: applied[m.Version]}
+		delete(applied, m.Version)
+	}
+	if len(applied) != 0 {
+		return out, ErrSchemaAhead
+	}
+	return out, nil
+}
+
+// Pending returns the migrations which still need to be applied.
+func (c *DB) Pending(ctx context.Context) ([]Migration, error) {
+	status, err := c.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, s := range status {
+		if !s.IsApplied() {
+			out = append(out, s.Migration)
+		}
+	}
+	return out, nil
+}
+
+// CheckSchema returns ErrSchemaBehind when some migrations are not applied.
+func (c *DB) CheckSchema(ctx context.Context) error {
+	pending, err := c.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) != 0 {
+		return xerrors.New(ErrSchemaBehind, pending[0].String())
+	}
+	return nil
+}
+
+// MigrateUp applies all the pending migrations, each one in its own
+// transaction, and returns the ones which were applied. The search index is
+// set up once the schema is up to date.
+func (c *DB) MigrateUp(ctx context.Context) ([]Migration, error) {
+	pending, err := c.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) != 0 {
+		if err := c.dropSearch(ctx); err != nil {
+			return nil, xerrors.New(ErrMigration, "search index", err)
+		}
+	}
+	for i, m := range pending {
+		err := c.transaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return pending[:i], xerrors.New(ErrMigration, m.String(), err)
+		}
+	}
+	if err := c.SetupSearch(ctx); err != nil {
+		return pending, xerrors.New(ErrMigration, "search index", err)
+	}
+	return pending, nil
+}
+
+// MigrateDown reverts the last applied migrations, up to steps of them. The
+// search index is dropped, MigrateUp sets it up again.
+func (c *DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	status, err := c.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.dropSearch(ctx); err != nil {
+		return nil, xerrors.New(ErrMigration, "search index", err)
+	}
+	var reverted []Migration
+	for i := len(status) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := status[i]
+		if !m.IsApplied() {
+			continue
+		}
+		if m.Down == "" {
+			return reverted, xerrors.New(ErrNoDownMigration, m.String())
+		}
+		err := c.transaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx,
+				"DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, xerrors.New(ErrMigration, m.String(), err)
+		}
+		reverted = append(reverted, m.Migration)
+	}
+	return reverted, nil
+}
+
+func (c *DB) transaction(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return xerrors.Append(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// CreateMigration writes a new pair of empty migration files in dir, numbered
+// after the highest version found there. It returns the created paths.
+func CreateMigration(dir, name string) (up, down string, err error) {
+	name = strings.ToLower(strings.Join(strings.Fields(name), "_"))
+	if !regexp.MustCompile(`^\w+$`).MatchString(name) {
+		return "", "", xerrors.New(ErrMigrationFile, "invalid name", name)
+	}
+	all, err := loadMigrations(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	version := 1
+	if len(all) != 0 {
+		version = all[len(all)-1].Version + 1
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%03d_%s", version, name))
+	up, down = base+".sql", base+".down.sql"
+	if err := os.WriteFile(up, []byte("-- "+name+"\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte("-- revert "+name+"\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
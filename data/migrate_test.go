@@ -0,0 +1,285 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// newMemoryDB opens an empty in-memory database. Each connection would get
+// its own database, the pool is limited to one.
+func newMemoryDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// withMigrations makes the DB methods read the migrations from fsys for the
+// duration of the test.
+func withMigrations(t *testing.T, fsys fs.FS) {
+	t.Helper()
+	previous := migrations
+	migrations = fsys
+	t.Cleanup(func() { migrations = previous })
+}
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func versions(ms []Migration) []int {
+	out := make([]int, len(ms))
+	for i, m := range ms {
+		out[i] = m.Version
+	}
+	return out
+}
+
+func tableExists(t *testing.T, db *DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := db.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)",
+		name).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fsys     fstest.MapFS
+		expected []Migration
+		err      error
+	}{
+		{name: "ordered by version", fsys: fstest.MapFS{
+			"010_later.sql":       file("later"),
+			"002_second.sql":      file("second"),
+			"002_second.down.sql": file("revert second"),
+			"001_first.sql":       file("first"),
+			"README.md":           file("ignored"),
+		}, expected: []Migration{
+			{Version: 1, Name: "first", Up: "first"},
+			{Version: 2, Name: "second", Up: "second", Down: "revert second"},
+			{Version: 10, Name: "later", Up: "later"},
+		}},
+		{name: "empty", fsys: fstest.MapFS{}, expected: []Migration{}},
+		{name: "invalid name", fsys: fstest.MapFS{"first.sql": file("")},
+			err: ErrMigrationFile},
+		{name: "dashes", fsys: fstest.MapFS{"001_first-one.sql": file("")},
+			err: ErrMigrationFile},
+		{name: "missing up", fsys: fstest.MapFS{"001_first.down.sql": file("revert")},
+			err: ErrMigrationFile},
+		{name: "duplicate version", fsys: fstest.MapFS{
+			"001_first.sql": file("first"), "001_other.sql": file("other")},
+			err: ErrMigrationFile},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := loadMigrations(tc.fsys)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %+v\ngot      %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := newMemoryDB(t)
+	all, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if !reflect.DeepEqual(versions(applied), versions(all)) {
+			t.Fatalf("round %d: applied %v", round, versions(applied))
+		}
+		if err := db.CheckSchema(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// applying again is a no-op
+		if applied, err := db.MigrateUp(ctx); err != nil || len(applied) != 0 {
+			t.Fatalf("round %d: applied %v again, %v", round, versions(applied), err)
+		}
+
+		reverted, err := db.MigrateDown(ctx, len(all))
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if len(reverted) != len(all) || reverted[0].Version != all[len(all)-1].Version {
+			t.Fatalf("round %d: reverted %v", round, versions(reverted))
+		}
+		if pending, err := db.Pending(ctx); err != nil || len(pending) != len(all) {
+			t.Fatalf("round %d: pending %v, %v", round, versions(pending), err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	base := fstest.MapFS{
+		"001_a.sql":      file("CREATE TABLE a (id INTEGER);"),
+		"001_a.down.sql": file("DROP TABLE a;"),
+		"002_b.sql":      file("CREATE TABLE b (id INTEGER);"),
+		"002_b.down.sql": file("DROP TABLE b;"),
+		"003_c.sql":      file("CREATE TABLE c (id INTEGER);"),
+		"003_c.down.sql": file("DROP TABLE c;"),
+	}
+	with := func(extra fstest.MapFS) fstest.MapFS {
+		out := fstest.MapFS{}
+		for name, f := range base {
+			out[name] = f
+		}
+		for name, f := range extra {
+			out[name] = f
+		}
+		return out
+	}
+
+	t.Run("down in reverse order", func(t *testing.T) {
+		withMigrations(t, base)
+		db := newMemoryDB(t)
+		if _, err := db.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+		reverted, err := db.MigrateDown(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := versions(reverted); !reflect.DeepEqual(got, []int{3, 2}) {
+			t.Errorf("expected 3 then 2 reverted, got %v", got)
+		}
+		if !tableExists(t, db, "a") || tableExists(t, db, "b") || tableExists(t, db, "c") {
+			t.Error("unexpected tables after reverting")
+		}
+		if pending, _ := db.Pending(ctx); !reflect.DeepEqual(versions(pending), []int{2, 3}) {
+			t.Errorf("expected 2 and 3 pending, got %v", versions(pending))
+		}
+	})
+
+	t.Run("failure rolls back", func(t *testing.T) {
+		withMigrations(t, with(fstest.MapFS{
+			"002_b.sql": file("CREATE TABLE b (id INTEGER); INSERT INTO missing VALUES (1);"),
+		}))
+		db := newMemoryDB(t)
+		applied, err := db.MigrateUp(ctx)
+		if !errors.Is(err, ErrMigration) {
+			t.Fatalf("expected migration error, got %v", err)
+		}
+		if got := versions(applied); !reflect.DeepEqual(got, []int{1}) {
+			t.Errorf("expected only 1 applied, got %v", got)
+		}
+		if !tableExists(t, db, "a") || tableExists(t, db, "b") || tableExists(t, db, "c") {
+			t.Error("the failed migration was not rolled back")
+		}
+		if err := db.CheckSchema(ctx); !errors.Is(err, ErrSchemaBehind) {
+			t.Errorf("expected schema behind, got %v", err)
+		}
+	})
+
+	t.Run("no down migration", func(t *testing.T) {
+		fsys := with(nil)
+		delete(fsys, "003_c.down.sql")
+		withMigrations(t, fsys)
+		db := newMemoryDB(t)
+		if _, err := db.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+		reverted, err := db.MigrateDown(ctx, 1)
+		if !errors.Is(err, ErrNoDownMigration) || len(reverted) != 0 {
+			t.Fatalf("expected no down migration, got %v reverted, %v", versions(reverted), err)
+		}
+		if !tableExists(t, db, "c") {
+			t.Error("table dropped without down migration")
+		}
+	})
+
+	t.Run("schema ahead", func(t *testing.T) {
+		withMigrations(t, base)
+		db := newMemoryDB(t)
+		if _, err := db.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+		fsys := with(nil)
+		delete(fsys, "003_c.sql")
+		delete(fsys, "003_c.down.sql")
+		migrations = fsys
+		if _, err := db.MigrationStatus(ctx); !errors.Is(err, ErrSchemaAhead) {
+			t.Errorf("expected schema ahead, got %v", err)
+		}
+	})
+}
+
+func TestCreateMigration(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		existing []string
+		create   string
+		expected string
+		err      error
+	}{
+		{name: "first", create: "add users", expected: "001_add_users"},
+		{name: "numbered after the last", existing: []string{"001_a.sql", "007_b.sql", "007_b.down.sql"},
+			create: "Add Index", expected: "008_add_index"},
+		{name: "invalid name", create: "add-users", err: ErrMigrationFile},
+		{name: "invalid existing", existing: []string{"notes.sql"}, create: "a", err: ErrMigrationFile},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tc.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("--"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			up, down, err := CreateMigration(dir, tc.create)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := filepath.Join(dir, tc.expected+".sql"); up != expected {
+				t.Errorf("expected %s, got %s", expected, up)
+			}
+			if expected := filepath.Join(dir, tc.expected+".down.sql"); down != expected {
+				t.Errorf("expected %s, got %s", expected, down)
+			}
+			// the created pair is a valid migration
+			all, err := loadMigrations(os.DirFS(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := all[len(all)-1]; last.String() != tc.expected || last.Down == "" {
+				t.Errorf("unexpected migration %+v", last)
+			}
+		})
+	}
+}
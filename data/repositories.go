@@ -0,0 +1,35 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/bokwoon95/sq"
+)
+
+var userRepositories = sq.New[USER_REPOSITORIES]("")
+
+// SaveRepositoryOrder replaces the ordering of the user with ids, first one
+// on top.
+func (c *DB) SaveRepositoryOrder(ctx context.Context, userID int64, ids []int64) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		_, err := sq.ExecContext(ctx, tx, sq.
+			DeleteFrom(userRepositories).
+			Where(userRepositories.USER_ID.EqInt64(userID)).
+			SetDialect(sq.DialectSQLite))
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		_, err = sq.ExecContext(ctx, tx, sq.
+			InsertInto(userRepositories).
+			ColumnValues(func(col *sq.Column) {
+				for i, id := range ids {
+					col.SetInt64(userRepositories.USER_ID, userID)
+					col.SetInt64(userRepositories.REPOSITORY_ID, id)
+					col.SetInt(userRepositories.POSITION, i)
+				}
+			}).
+			SetDialect(sq.DialectSQLite))
+		return err
+	})
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/bokwoon95/sq"
+	"github.com/platipy-io/d2s/types"
+)
+
+type StarredSort string
+
+const (
+	// SortCustom is the order saved by the user, the repositories starred
+	// since come first.
+	SortCustom  StarredSort = ""
+	SortUpdated StarredSort = "updated"
+	SortName    StarredSort = "name"
+)
+
+// maxSearchTerms bounds the predicates built from a search.
+const maxSearchTerms = 8
+
+// StarredQuery filters and orders the starred repositories of a user.
+type StarredQuery struct {
+	// Search matches each of its words as the prefix of a word of the
+	// owner, the name, the description or the topics, case and accents
+	// insensitively. Without full text search, each word has to appear
+	// anywhere in them, case insensitively for ASCII only.
+	Search   string
+	Language string
+	Sort     StarredSort
+}
+
+// IsZero reports whether the query lists every repository in custom order.
+func (q StarredQuery) IsZero() bool {
+	return q == StarredQuery{}
+}
+
+// searchSchema indexes the starred repositories with FTS5, the text is read
+// from the repositories table and the triggers keep the index in sync with it.
+// The index is rebuilt as the triggers may have been missing for a while.
+const searchSchema = `CREATE VIRTUAL TABLE IF NOT EXISTS repositories_search USING fts5(
+	owner, name, description, topics,
+	content='repositories', content_rowid='rowid',
+	tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER repositories_search_insert AFTER INSERT ON repositories BEGIN
+	INSERT INTO repositories_search (rowid, owner, name, description, topics)
+	VALUES (new.rowid, new.owner, new.name, new.description, new.topics);
+END;
+
+CREATE TRIGGER repositories_search_delete AFTER DELETE ON repositories BEGIN
+	INSERT INTO repositories_search (repositories_search, rowid, owner, name, description, topics)
+	VALUES ('delete', old.rowid, old.owner, old.name, old.description, old.topics);
+END;
+
+CREATE TRIGGER repositories_search_update AFTER UPDATE ON repositories BEGIN
+	INSERT INTO repositories_search (repositories_search, rowid, owner, name, description, topics)
+	VALUES ('delete', old.rowid, old.owner, old.name, old.description, old.topics);
+	INSERT INTO repositories_search (rowid, owner, name, description, topics)
+	VALUES (new.rowid, new.owner, new.name, new.description, new.topics);
+END;
+
+INSERT INTO repositories_search (repositories_search) VALUES ('rebuild');`
+
+// dropSearchTriggers stops maintaining the index, the table itself can't be
+// dropped without the extension and is left as is. It is rebuilt when the
+// triggers are created again.
+const dropSearchTriggers = `DROP TRIGGER IF EXISTS repositories_search_insert;
+DROP TRIGGER IF EXISTS repositories_search_delete;
+DROP TRIGGER IF EXISTS repositories_search_update;`
+
+// SetupSearch indexes the starred repositories for full text search when
+// SQLite was built with FTS5, which go-sqlite3 only does with the sqlite_fts5
+// build tag. Searches fall back to LIKE otherwise, and the triggers left by a
+// build with the extension are dropped as they would fail every write. It is
+// run by MigrateUp and must be called on an up to date schema otherwise,
+// nothing is indexed before the repositories table is created.
+func (c *DB) SetupSearch(ctx context.Context) error {
+	var available bool
+	err := c.db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return c.dropSearch(ctx)
+	}
+	var copied bool
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		var indexed bool
+		err := tx.QueryRowContext(ctx, `SELECT
+			EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'repositories'),
+			EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'repositories_search_insert')`,
+		).Scan(&copied, &indexed)
+		if err != nil || !copied || indexed {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, searchSchema)
+		return err
+	})
+	c.fullText.Store(err == nil && copied)
+	return err
+}
+
+// dropSearch stops maintaining the index, the triggers would get in the way of
+// migrations changing the columns they read.
+func (c *DB) dropSearch(ctx context.Context) error {
+	c.fullText.Store(false)
+	_, err := c.db.ExecContext(ctx, dropSearchTriggers)
+	return err
+}
+
+// FullTextSearch reports whether searches go through the full text index.
+func (c *DB) FullTextSearch() bool {
+	return c.fullText.Load()
+}
+
+// searchTerm splits a search the way the full text index splits the text, so
+// owner/name matches both parts.
+var searchTerm = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// searchMatch builds the full text query of search, each term is quoted so
+// none is taken for a query operator.
+func searchMatch(search string) string {
+	terms := searchTerm.FindAllString(search, maxSearchTerms)
+	for i, term := range terms {
+		terms[i] = `"` + term + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (q StarredQuery) searchPredicates(fullText bool) (predicates []sq.Predicate) {
+	if fullText {
+		if match := searchMatch(q.Search); match != "" {
+			predicates = append(predicates, sq.Expr(`repositories.rowid IN (SELECT rowid FROM repositories_search WHERE repositories_search MATCH {})`, match))
+		}
+		return predicates
+	}
+	terms := strings.Fields(q.Search)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	for _, term := range terms {
+		predicates = append(predicates, sq.Expr(`({} || '/' || {} || ' ' || {} || ' ' || {}) LIKE {} ESCAPE '\'`,
+			repositories.OWNER, repositories.NAME, repositories.DESCRIPTION, repositories.TOPICS,
+			"%"+likeEscaper.Replace(term)+"%"))
+	}
+	return predicates
+}
+
+// SearchStarred returns the repositories of the user matching query, at most
+// limit of them after skipping offset. All of them are returned when limit is
+// 0 or less.
+func (c *DB) SearchStarred(ctx context.Context, userID int64, query StarredQuery, limit, offset int) ([]*types.Repository, error) {
+	predicates := append(query.searchPredicates(c.FullTextSearch()), repositories.USER_ID.EqInt64(userID))
+	if query.Language != "" {
+		predicates = append(predicates, repositories.LANGUAGE.EqString(query.Language))
+	}
+	var order []sq.Field
+	switch query.Sort {
+	case SortUpdated:
+		order = []sq.Field{repositories.UPDATED.Desc(), repositories.RANK}
+	case SortName:
+		order = []sq.Field{sq.Expr("{} COLLATE NOCASE", repositories.OWNER),
+			sq.Expr("{} COLLATE NOCASE", repositories.NAME)}
+	default:
+		// unordered repositories first, in the order of the provider
+		order = []sq.Field{userRepositories.POSITION.IsNotNull(),
+			userRepositories.POSITION, repositories.RANK}
+	}
+	selection := sq.
+		From(repositories).
+		LeftJoin(userRepositories,
+			userRepositories.USER_ID.Eq(repositories.USER_ID),
+			userRepositories.REPOSITORY_ID.Eq(repositories.ID)).
+		Where(predicates...).
+		OrderBy(order...).
+		SetDialect(sq.DialectSQLite)
+	if limit > 0 {
+		selection = selection.Limit(limit).Offset(max(offset, 0))
+	}
+	return sq.FetchAllContext(ctx, c.db, selection, repositoryRow)
+}
+
+// StarredLanguages counts the repositories of the user matching the search of
+// query per language, most used first. The language of query is ignored so
+// every alternative is listed.
+func (c *DB) StarredLanguages(ctx context.Context, userID int64, query StarredQuery) ([]types.LanguageCount, error) {
+	predicates := append(query.searchPredicates(c.FullTextSearch()),
+		repositories.USER_ID.EqInt64(userID), repositories.LANGUAGE.NeString(""))
+	return sq.FetchAllContext(ctx, c.db, sq.
+		From(repositories).
+		Where(predicates...).
+		GroupBy(repositories.LANGUAGE).
+		OrderBy(sq.Expr("COUNT(*) DESC"), repositories.LANGUAGE).
+		SetDialect(sq.DialectSQLite),
+		func(row *sq.Row) types.LanguageCount {
+			return types.LanguageCount{
+				Language: row.StringField(repositories.LANGUAGE),
+				Count:    row.Int("COUNT(*)"),
+			}
+		})
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+func TestSearchStarredPage(t *testing.T) {
+	ctx := context.Background()
+	db := newSeededDB(t, filepath.Join(t.TempDir(), "d2s.db"), "user@example.com")
+	user, err := db.GetUserByEmail(ctx, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repos []*types.Repository
+	for i := int64(1); i <= 5; i++ {
+		repos = append(repos, &types.Repository{ID: i, Owner: "owner", Name: "repo" + strconv.FormatInt(i, 10)})
+	}
+	if err := db.SaveStarred(ctx, repos, &types.SyncState{UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		limit, offset int
+		expected      []int64
+	}{
+		{name: "all", expected: []int64{1, 2, 3, 4, 5}},
+		{name: "first page", limit: 2, expected: []int64{1, 2}},
+		{name: "middle page", limit: 2, offset: 2, expected: []int64{3, 4}},
+		{name: "last page", limit: 2, offset: 4, expected: []int64{5}},
+		{name: "past the end", limit: 2, offset: 6, expected: []int64{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := db.SearchStarred(ctx, user.ID, StarredQuery{}, tc.limit, tc.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]int64, len(got))
+			for i, repo := range got {
+				ids[i] = repo.ID
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bokwoon95/sq"
+	"github.com/mdobak/go-xerrors"
+	"github.com/platipy-io/d2s/types"
+)
+
+var sessions = sq.New[SESSIONS]("")
+
+var ErrNotFound = xerrors.Message("not found")
+
+func (c *DB) CreateSession(ctx context.Context, session *types.Session) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		InsertInto(sessions).
+		Columns(sessions.ID, sessions.USER_ID, sessions.DATA, sessions.CREATED, sessions.EXPIRES).
+		Values(session.ID, session.UserID, session.Data, session.Created.UTC(), session.Expires.UTC()).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// GetSession returns the session matching id, ErrNotFound is returned if it
+// does not exist or has expired.
+func (c *DB) GetSession(ctx context.Context, id string) (*types.Session, error) {
+	session, err := sq.FetchOneContext(ctx, c.db, sq.
+		From(sessions).
+		Where(sessions.ID.EqString(id), sessions.EXPIRES.GtTime(time.Now().UTC())).
+		SetDialect(sq.DialectSQLite),
+		func(row *sq.Row) *types.Session {
+			return &types.Session{
+				ID:      row.StringField(sessions.ID),
+				UserID:  row.Int64Field(sessions.USER_ID),
+				Data:    row.BytesField(sessions.DATA),
+				Created: row.TimeField(sessions.CREATED),
+				Expires: row.TimeField(sessions.EXPIRES),
+			}
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return session, err
+}
+
+func (c *DB) TouchSession(ctx context.Context, id string, expires time.Time) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		Update(sessions).
+		Set(sessions.EXPIRES.SetTime(expires.UTC())).
+		Where(sessions.ID.EqString(id)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+func (c *DB) DeleteSession(ctx context.Context, id string) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(sessions).
+		Where(sessions.ID.EqString(id)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// DeleteSessions revokes all the sessions of the user.
+func (c *DB) DeleteSessions(ctx context.Context, userID int64) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(sessions).
+		Where(sessions.USER_ID.EqInt64(userID)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// PurgeSessions removes the sessions which expired before now and returns
+// how many were removed.
+func (c *DB) PurgeSessions(ctx context.Context, now time.Time) (int64, error) {
+	res, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(sessions).
+		Where(sessions.EXPIRES.LtTime(now.UTC())).
+		SetDialect(sq.DialectSQLite))
+	return res.RowsAffected, err
+}
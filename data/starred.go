@@ -0,0 +1,257 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bokwoon95/sq"
+	"github.com/platipy-io/d2s/types"
+)
+
+var (
+	repositories = sq.New[REPOSITORIES]("")
+	syncStates   = sq.New[SYNC_STATE]("")
+)
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// topics stores the absence of topics as an empty array rather than null.
+func topics(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func repositoryRow(row *sq.Row) *types.Repository {
+	repo := &types.Repository{
+		ID:          row.Int64Field(repositories.ID),
+		Owner:       row.StringField(repositories.OWNER),
+		Name:        row.StringField(repositories.NAME),
+		Description: row.StringField(repositories.DESCRIPTION),
+		Language:    row.StringField(repositories.LANGUAGE),
+		LastUpdated: row.TimeField(repositories.UPDATED),
+		HTMLURL:     row.StringField(repositories.HTML_URL),
+		Stars:       row.IntField(repositories.STARS),
+		Forks:       row.IntField(repositories.FORKS),
+		Archived:    row.BoolField(repositories.ARCHIVED),
+		Fork:        row.BoolField(repositories.FORK),
+	}
+	row.JSONField(&repo.Topics, repositories.TOPICS)
+	return repo
+}
+
+// StarredRepositories returns the local copy of the repositories starred by
+// the user, most recently starred first.
+func (c *DB) StarredRepositories(ctx context.Context, userID int64) ([]*types.Repository, error) {
+	return sq.FetchAllContext(ctx, c.db, sq.
+		From(repositories).
+		Where(repositories.USER_ID.EqInt64(userID)).
+		OrderBy(repositories.RANK).
+		SetDialect(sq.DialectSQLite),
+		repositoryRow)
+}
+
+// SaveStarred replaces the local copy of the starred repositories of the user
+// and records state, in a single transaction.
+func (c *DB) SaveStarred(ctx context.Context, repos []*types.Repository, state *types.SyncState) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		_, err := sq.ExecContext(ctx, tx, sq.
+			DeleteFrom(repositories).
+			Where(repositories.USER_ID.EqInt64(state.UserID)).
+			SetDialect(sq.DialectSQLite))
+		if err != nil {
+			return err
+		}
+		if len(repos) != 0 {
+			_, err = sq.ExecContext(ctx, tx, sq.
+				InsertInto(repositories).
+				ColumnValues(func(col *sq.Column) {
+					for i, repo := range repos {
+						col.SetInt64(repositories.USER_ID, state.UserID)
+						col.SetInt64(repositories.ID, repo.ID)
+						col.SetString(repositories.OWNER, repo.Owner)
+						col.SetString(repositories.NAME, repo.Name)
+						col.SetString(repositories.DESCRIPTION, repo.Description)
+						col.SetString(repositories.LANGUAGE, repo.Language)
+						col.Set(repositories.UPDATED, nullTime(repo.LastUpdated))
+						col.SetInt(repositories.RANK, i)
+						col.SetString(repositories.HTML_URL, repo.HTMLURL)
+						col.SetInt(repositories.STARS, repo.Stars)
+						col.SetInt(repositories.FORKS, repo.Forks)
+						col.SetJSON(repositories.TOPICS, topics(repo.Topics))
+						col.SetBool(repositories.ARCHIVED, repo.Archived)
+						col.SetBool(repositories.FORK, repo.Fork)
+					}
+				}).
+				SetDialect(sq.DialectSQLite))
+			if err != nil {
+				return err
+			}
+		}
+		return saveSyncState(ctx, tx, state)
+	})
+}
+
+// SyncState returns the sync state of the user, ErrNotFound is returned if
+// the user was never synced.
+func (c *DB) SyncState(ctx context.Context, userID int64) (*types.SyncState, error) {
+	state, err := sq.FetchOneContext(ctx, c.db, sq.
+		From(syncStates).
+		Where(syncStates.USER_ID.EqInt64(userID)).
+		SetDialect(sq.DialectSQLite),
+		func(row *sq.Row) *types.SyncState {
+			return &types.SyncState{
+				UserID:  row.Int64Field(syncStates.USER_ID),
+				ETag:    row.StringField(syncStates.ETAG),
+				Checked: row.TimeField(syncStates.CHECKED),
+				Synced:  row.TimeField(syncStates.SYNCED),
+				Error:   row.StringField(syncStates.ERROR),
+			}
+		})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return state, err
+}
+
+// SaveSyncState records an attempt which did not change the repositories,
+// either because nothing changed or because it failed.
+func (c *DB) SaveSyncState(ctx context.Context, state *types.SyncState) error {
+	return saveSyncState(ctx, c.db, state)
+}
+
+func saveSyncState(ctx context.Context, db sq.DB, state *types.SyncState) error {
+	checked, synced := nullTime(state.Checked), nullTime(state.Synced)
+	_, err := sq.ExecContext(ctx, db, sq.SQLite.
+		InsertInto(syncStates).
+		Columns(syncStates.USER_ID, syncStates.ETAG, syncStates.CHECKED,
+			syncStates.SYNCED, syncStates.ERROR).
+		Values(state.UserID, state.ETag, checked, synced, state.Error).
+		OnConflict(syncStates.USER_ID).
+		DoUpdateSet(
+			syncStates.ETAG.SetString(state.ETag),
+			syncStates.CHECKED.Set(checked),
+			syncStates.SYNCED.Set(synced),
+			syncStates.ERROR.SetString(state.Error),
+		))
+	return err
+}
+
+// UsersToSync returns the users holding a token of provider and an active
+// session, whose repositories were not checked since before.
+func (c *DB) UsersToSync(ctx context.Context, provider string, before time.Time) ([]int64, error) {
+	active := sq.
+		Select(sq.Expr("1")).
+		From(sessions).
+		Where(sessions.USER_ID.Eq(tokens.USER_ID), sessions.EXPIRES.GtTime(time.Now().UTC()))
+	return sq.FetchAllContext(ctx, c.db, sq.
+		From(tokens).
+		LeftJoin(syncStates, syncStates.USER_ID.Eq(tokens.USER_ID)).
+		Where(
+			tokens.PROVIDER.EqString(provider),
+			sq.Exists(active),
+			sq.Or(syncStates.CHECKED.IsNull(), syncStates.CHECKED.LtTime(before.UTC())),
+		).
+		SetDialect(sq.DialectSQLite),
+		func(row *sq.Row) int64 { return row.Int64Field(tokens.USER_ID) })
+}
+
+// AddStarred puts repo on top of the local copy of the user, as the most
+// recently starred. The details are refreshed if it is already there.
+func (c *DB) AddStarred(ctx context.Context, userID int64, repo *types.Repository) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		rank, err := sq.FetchOneContext(ctx, tx, sq.
+			From(repositories).
+			Where(repositories.USER_ID.EqInt64(userID)).
+			SetDialect(sq.DialectSQLite),
+			func(row *sq.Row) int { return row.Int("COALESCE(MIN({}), 0) - 1", repositories.RANK) })
+		if err != nil {
+			return err
+		}
+		_, err = sq.ExecContext(ctx, tx, sq.SQLite.
+			InsertInto(repositories).
+			ColumnValues(func(col *sq.Column) {
+				col.SetInt64(repositories.USER_ID, userID)
+				col.SetInt64(repositories.ID, repo.ID)
+				col.SetString(repositories.OWNER, repo.Owner)
+				col.SetString(repositories.NAME, repo.Name)
+				col.SetString(repositories.DESCRIPTION, repo.Description)
+				col.SetString(repositories.LANGUAGE, repo.Language)
+				col.Set(repositories.UPDATED, nullTime(repo.LastUpdated))
+				col.SetInt(repositories.RANK, rank)
+				col.SetString(repositories.HTML_URL, repo.HTMLURL)
+				col.SetInt(repositories.STARS, repo.Stars)
+				col.SetInt(repositories.FORKS, repo.Forks)
+				col.SetJSON(repositories.TOPICS, topics(repo.Topics))
+				col.SetBool(repositories.ARCHIVED, repo.Archived)
+				col.SetBool(repositories.FORK, repo.Fork)
+			}).
+			OnConflict(repositories.USER_ID, repositories.ID).
+			DoUpdateSet(repositoryDetails(repo)...))
+		return err
+	})
+}
+
+// RemoveStarred removes the repository from the local copy of the user.
+func (c *DB) RemoveStarred(ctx context.Context, userID, repoID int64) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(repositories).
+		Where(repositories.USER_ID.EqInt64(userID), repositories.ID.EqInt64(repoID)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// UpdateRepository refreshes the details of repo in the copies of every user
+// who starred it.
+func (c *DB) UpdateRepository(ctx context.Context, repo *types.Repository) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		Update(repositories).
+		Set(repositoryDetails(repo)...).
+		Where(repositories.ID.EqInt64(repo.ID)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// DeleteRepository removes the repository from the copies of every user.
+func (c *DB) DeleteRepository(ctx context.Context, repoID int64) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(repositories).
+		Where(repositories.ID.EqInt64(repoID)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// ExpireSyncState makes the next background sync of the user fetch the whole
+// listing.
+func (c *DB) ExpireSyncState(ctx context.Context, userID int64) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		Update(syncStates).
+		Set(syncStates.ETAG.SetString(""), syncStates.CHECKED.Set(nil)).
+		Where(syncStates.USER_ID.EqInt64(userID)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// repositoryDetails are the columns describing repo, as reported by the
+// provider.
+func repositoryDetails(repo *types.Repository) []sq.Assignment {
+	return []sq.Assignment{
+		repositories.OWNER.SetString(repo.Owner),
+		repositories.NAME.SetString(repo.Name),
+		repositories.DESCRIPTION.SetString(repo.Description),
+		repositories.LANGUAGE.SetString(repo.Language),
+		repositories.UPDATED.Set(nullTime(repo.LastUpdated)),
+		repositories.HTML_URL.SetString(repo.HTMLURL),
+		repositories.STARS.SetInt(repo.Stars),
+		repositories.FORKS.SetInt(repo.Forks),
+		repositories.TOPICS.SetJSON(topics(repo.Topics)),
+		repositories.ARCHIVED.SetBool(repo.Archived),
+		repositories.FORK.SetBool(repo.Fork),
+	}
+}
@@ -3,9 +3,92 @@ package data
 import "github.com/bokwoon95/sq"
 
 type USERS struct {
+	sq.TableStruct
+	ID         sq.NumberField `ddl:"primarykey"`
+	EMAIL      sq.StringField `ddl:"notnull default=''"`
+	NAME       sq.StringField `ddl:"notnull default=''"`
+	AVATAR     sq.StringField `ddl:"notnull default=''"`
+	PROVIDER   sq.StringField `ddl:"notnull default=''"`
+	SUBJECT    sq.StringField `ddl:"notnull default=''"`
+	CREATED    sq.TimeField   `ddl:"type=DATETIME"`
+	LAST_LOGIN sq.TimeField   `ddl:"type=DATETIME"`
+}
+
+type SESSIONS struct {
+	sq.TableStruct
+	ID      sq.StringField `ddl:"primarykey"`
+	USER_ID sq.NumberField `ddl:"notnull index references={users.id ondelete=cascade}"`
+	DATA    sq.BinaryField `ddl:"notnull"`
+	CREATED sq.TimeField   `ddl:"notnull type=DATETIME"`
+	EXPIRES sq.TimeField   `ddl:"notnull type=DATETIME index"`
+}
+
+type TOKENS struct {
+	sq.TableStruct `ddl:"primarykey={user_id,provider}"`
+	USER_ID        sq.NumberField `ddl:"notnull references={users.id ondelete=cascade}"`
+	PROVIDER       sq.StringField `ddl:"notnull"`
+	DATA           sq.BinaryField `ddl:"notnull"`
+	UPDATED        sq.TimeField   `ddl:"notnull type=DATETIME"`
+}
+
+type USER_REPOSITORIES struct {
+	sq.TableStruct `ddl:"primarykey={user_id,repository_id}"`
+	USER_ID        sq.NumberField `ddl:"notnull references={users.id ondelete=cascade}"`
+	REPOSITORY_ID  sq.NumberField `ddl:"notnull"`
+	POSITION       sq.NumberField `ddl:"notnull"`
+}
+
+type REPOSITORIES struct {
+	sq.TableStruct `ddl:"primarykey={user_id,id}"`
+	USER_ID        sq.NumberField  `ddl:"notnull references={users.id ondelete=cascade}"`
+	ID             sq.NumberField  `ddl:"notnull"`
+	OWNER          sq.StringField  `ddl:"notnull default=''"`
+	NAME           sq.StringField  `ddl:"notnull default=''"`
+	DESCRIPTION    sq.StringField  `ddl:"notnull default=''"`
+	LANGUAGE       sq.StringField  `ddl:"notnull default=''"`
+	UPDATED        sq.TimeField    `ddl:"type=DATETIME"`
+	RANK           sq.NumberField  `ddl:"notnull"`
+	HTML_URL       sq.StringField  `ddl:"notnull default=''"`
+	STARS          sq.NumberField  `ddl:"notnull default=0"`
+	FORKS          sq.NumberField  `ddl:"notnull default=0"`
+	TOPICS         sq.JSONField    `ddl:"notnull default='[]'"`
+	ARCHIVED       sq.BooleanField `ddl:"notnull default=FALSE"`
+	FORK           sq.BooleanField `ddl:"notnull default=FALSE"`
+}
+
+type SYNC_STATE struct {
+	sq.TableStruct
+	USER_ID sq.NumberField `ddl:"primarykey references={users.id ondelete=cascade}"`
+	ETAG    sq.StringField `ddl:"notnull default=''"`
+	CHECKED sq.TimeField   `ddl:"type=DATETIME"`
+	SYNCED  sq.TimeField   `ddl:"type=DATETIME"`
+	ERROR   sq.StringField `ddl:"notnull default=''"`
+}
+
+type COLLECTIONS struct {
+	sq.TableStruct `ddl:"unique={user_id,name}"`
+	ID             sq.NumberField `ddl:"primarykey"`
+	USER_ID        sq.NumberField `ddl:"notnull references={users.id ondelete=cascade}"`
+	NAME           sq.StringField `ddl:"notnull"`
+	SHARE_TOKEN    sq.StringField `ddl:"notnull default=''"`
+	CREATED        sq.TimeField   `ddl:"notnull type=DATETIME"`
+}
+
+type COLLECTION_REPOSITORIES struct {
+	sq.TableStruct `ddl:"primarykey={collection_id,repository_id}"`
+	COLLECTION_ID  sq.NumberField `ddl:"notnull references={collections.id ondelete=cascade}"`
+	REPOSITORY_ID  sq.NumberField `ddl:"notnull"`
+	POSITION       sq.NumberField `ddl:"notnull"`
+}
+
+type WEBHOOK_DELIVERIES struct {
+	sq.TableStruct
+	ID       sq.StringField `ddl:"primarykey"`
+	RECEIVED sq.TimeField   `ddl:"notnull type=DATETIME index"`
+}
+
+type HEALTH_PROBES struct {
 	sq.TableStruct
 	ID      sq.NumberField `ddl:"primarykey"`
-	EMAIL   sq.StringField `ddl:"notnull unique default=''"`
-	NAME    sq.StringField `ddl:"notnull default=''"`
-	CREATED sq.TimeField   `ddl:"type=DATETIME"`
+	CHECKED sq.TimeField   `ddl:"notnull type=DATETIME"`
 }
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bokwoon95/sq"
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+)
+
+var tokens = sq.New[TOKENS]("")
+
+func tokenLabel(userID int64, provider string) string {
+	return "tokens:" + strconv.FormatInt(userID, 10) + ":" + provider
+}
+
+// SaveToken stores the token the user was granted by provider, replacing the
+// previous one. It is encrypted with the configured key.
+func (c *DB) SaveToken(ctx context.Context, userID int64, provider string, token *oauth2.Token) error {
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return xerrors.WithWrapper(ErrEncrypt, err)
+	}
+	encrypted, err := c.encrypt(tokenLabel(userID, provider), raw)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = sq.ExecContext(ctx, c.db, sq.SQLite.
+		InsertInto(tokens).
+		Columns(tokens.USER_ID, tokens.PROVIDER, tokens.DATA, tokens.UPDATED).
+		Values(userID, provider, encrypted, now).
+		OnConflict(tokens.USER_ID, tokens.PROVIDER).
+		DoUpdateSet(tokens.DATA.SetBytes(encrypted), tokens.UPDATED.SetTime(now)))
+	return err
+}
+
+// GetToken returns the token of the user for provider, ErrNotFound is returned
+// if none was stored.
+func (c *DB) GetToken(ctx context.Context, userID int64, provider string) (*oauth2.Token, error) {
+	encrypted, err := sq.FetchOneContext(ctx, c.db, sq.
+		From(tokens).
+		Where(tokens.USER_ID.EqInt64(userID), tokens.PROVIDER.EqString(provider)).
+		SetDialect(sq.DialectSQLite),
+		func(row *sq.Row) []byte { return row.BytesField(tokens.DATA) })
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	raw, err := c.decrypt(tokenLabel(userID, provider), encrypted)
+	if err != nil {
+		return nil, err
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(raw, token); err != nil {
+		return nil, xerrors.WithWrapper(ErrDecrypt, err)
+	}
+	return token, nil
+}
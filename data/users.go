@@ -1,27 +1,149 @@
 package data
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"time"
 
 	"github.com/bokwoon95/sq"
-	"github.com/mattn/go-sqlite3"
+	"github.com/mdobak/go-xerrors"
 	"github.com/platipy-io/d2s/types"
 )
 
 var users = sq.New[USERS]("")
 
-func (c *DB) SaveUser(user *types.User) error {
-	created := time.Now()
-	er := sqlite3.Error{}
+func userRow(row *sq.Row) *types.User {
+	return &types.User{
+		ID:        row.Int64Field(users.ID),
+		Email:     row.StringField(users.EMAIL),
+		Name:      row.StringField(users.NAME),
+		Avatar:    row.StringField(users.AVATAR),
+		Provider:  row.StringField(users.PROVIDER),
+		Subject:   row.StringField(users.SUBJECT),
+		Created:   row.TimeField(users.CREATED),
+		LastLogin: row.TimeField(users.LAST_LOGIN),
+	}
+}
+
+func getUser(ctx context.Context, db sq.DB, predicate sq.Predicate) (*types.User, error) {
+	user, err := sq.FetchOneContext(ctx, db, sq.
+		From(users).
+		Where(predicate).
+		SetDialect(sq.DialectSQLite), userRow)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// GetUserByID returns the user with the given primary key, ErrNotFound is
+// returned if there is none.
+func (c *DB) GetUserByID(ctx context.Context, id int64) (*types.User, error) {
+	return getUser(ctx, c.db, users.ID.EqInt64(id))
+}
+
+// GetUserByEmail returns the user owning email, ErrNotFound is returned if
+// there is none.
+func (c *DB) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	if email == "" {
+		return nil, ErrNotFound
+	}
+	return getUser(ctx, c.db, users.EMAIL.EqString(email))
+}
 
-	_, err := sq.Exec(c.db, sq.
-		InsertInto(users).
-		Columns(users.EMAIL, users.NAME, users.CREATED).
-		Values(user.Email, user.Name, created).
-		SetDialect(sq.DialectSQLite))
-	if errors.As(err, &er) && er.ExtendedCode == sqlite3.ErrConstraintUnique {
+// GetUserBySubject returns the user known by provider as subject, ErrNotFound
+// is returned if there is none.
+func (c *DB) GetUserBySubject(ctx context.Context, provider, subject string) (*types.User, error) {
+	if subject == "" {
+		return nil, ErrNotFound
+	}
+	return getUser(ctx, c.db, sq.And(users.PROVIDER.EqString(provider), users.SUBJECT.EqString(subject)))
+}
+
+// ErrEmailTaken is returned when logging in with a provider whose verified
+// email belongs to a user linked to another provider.
+var ErrEmailTaken = xerrors.Message("email belongs to a user of another provider")
+
+// UpsertUser records a login of user. The existing row is found by provider
+// identity and has its profile refreshed. Otherwise a row without identity
+// owning the email is linked to the provider, the email must then have been
+// verified by the provider, and a new row is created when there is none. The
+// identity of a row linked to another provider is never replaced,
+// ErrEmailTaken is returned instead. ID, Created and LastLogin of user are
+// filled in.
+func (c *DB) UpsertUser(ctx context.Context, user *types.User) error {
+	now := time.Now().UTC()
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		existing, err := findUser(ctx, tx, user)
+		if errors.Is(err, ErrNotFound) {
+			res, err := sq.ExecContext(ctx, tx, sq.
+				InsertInto(users).
+				Columns(users.EMAIL, users.NAME, users.AVATAR, users.PROVIDER,
+					users.SUBJECT, users.CREATED, users.LAST_LOGIN).
+				Values(user.Email, user.Name, user.Avatar, user.Provider,
+					user.Subject, now, now).
+				SetDialect(sq.DialectSQLite))
+			if err != nil {
+				return err
+			}
+			user.ID, user.Created, user.LastLogin = res.LastInsertId, now, now
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// providers may withhold the email, keep the one we know of; a new one
+		// already owned by another row is not taken over either
+		if user.Email != existing.Email && user.Email != "" {
+			if _, err := getUser(ctx, tx, users.EMAIL.EqString(user.Email)); err == nil {
+				user.Email = existing.Email
+			} else if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		if user.Email == "" {
+			user.Email = existing.Email
+		}
+		_, err = sq.ExecContext(ctx, tx, sq.
+			Update(users).
+			Set(
+				users.EMAIL.SetString(user.Email),
+				users.NAME.SetString(user.Name),
+				users.AVATAR.SetString(user.Avatar),
+				users.PROVIDER.SetString(user.Provider),
+				users.SUBJECT.SetString(user.Subject),
+				users.LAST_LOGIN.SetTime(now),
+			).
+			Where(users.ID.EqInt64(existing.ID)).
+			SetDialect(sq.DialectSQLite))
+		if err != nil {
+			return err
+		}
+		user.ID, user.Created, user.LastLogin = existing.ID, existing.Created, now
 		return nil
+	})
+}
+
+// findUser returns the row of the provider identity of user, or the row owning
+// its email as long as it isn't linked to any provider yet.
+func findUser(ctx context.Context, db sq.DB, user *types.User) (*types.User, error) {
+	if user.Subject != "" {
+		existing, err := getUser(ctx, db, sq.And(
+			users.PROVIDER.EqString(user.Provider), users.SUBJECT.EqString(user.Subject)))
+		if !errors.Is(err, ErrNotFound) {
+			return existing, err
+		}
+	}
+	if user.Email == "" {
+		return nil, ErrNotFound
+	}
+	existing, err := getUser(ctx, db, users.EMAIL.EqString(user.Email))
+	if err != nil {
+		return nil, err
+	}
+	if existing.Subject != "" {
+		return nil, xerrors.New(ErrEmailTaken, existing.Provider)
 	}
-	return err
+	return existing, nil
 }
@@ -0,0 +1,47 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bokwoon95/sq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/mdobak/go-xerrors"
+)
+
+var webhookDeliveries = sq.New[WEBHOOK_DELIVERIES]("")
+
+// RecordDelivery remembers the webhook delivery id, ErrDuplicate is returned
+// if it was already received.
+func (c *DB) RecordDelivery(ctx context.Context, id string, received time.Time) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		InsertInto(webhookDeliveries).
+		Columns(webhookDeliveries.ID, webhookDeliveries.RECEIVED).
+		Values(id, received.UTC()).
+		SetDialect(sq.DialectSQLite))
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return xerrors.New(ErrDuplicate, "delivery", id)
+	}
+	return err
+}
+
+// ForgetDelivery removes the webhook delivery id, so it is accepted again when
+// redelivered.
+func (c *DB) ForgetDelivery(ctx context.Context, id string) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(webhookDeliveries).
+		Where(webhookDeliveries.ID.EqString(id)).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
+
+// PurgeDeliveries removes the webhook deliveries received before before.
+func (c *DB) PurgeDeliveries(ctx context.Context, before time.Time) error {
+	_, err := sq.ExecContext(ctx, c.db, sq.
+		DeleteFrom(webhookDeliveries).
+		Where(webhookDeliveries.RECEIVED.LtTime(before.UTC())).
+		SetDialect(sq.DialectSQLite))
+	return err
+}
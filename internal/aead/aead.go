@@ -0,0 +1,80 @@
+// Package aead encrypts and authenticates small values with AES-256-GCM under
+// a list of keys: the first one seals new values while all of them open,
+// which allows rotating keys.
+package aead
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrNoKey = xerrors.Message("at least one key is required")
+	ErrSeal  = xerrors.Message("failed encrypting value")
+	ErrOpen  = xerrors.Message("failed decrypting value")
+)
+
+// Keyring seals and opens values with the ciphers derived from a list of
+// secrets, newest first.
+type Keyring struct {
+	aeads []cipher.AEAD
+}
+
+// New derives a cipher from each secret with HKDF under info, naming the
+// usage. Secrets of any length can be configured, and shared with other
+// usages (e.g. signing) without their keys ever matching.
+func New(info string, secrets ...[]byte) (*Keyring, error) {
+	if len(secrets) == 0 {
+		return nil, ErrNoKey
+	}
+	aeads := make([]cipher.AEAD, 0, len(secrets))
+	for _, secret := range secrets {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(info)), key); err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	return &Keyring{aeads: aeads}, nil
+}
+
+// Seal encrypts plaintext with the newest key, the nonce is prepended to the
+// result. The label is authenticated as additional data so a value can't be
+// moved from one context (e.g. cookie name, database row) to another.
+func (k *Keyring) Seal(label string, plaintext []byte) ([]byte, error) {
+	aead := k.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, xerrors.WithWrapper(ErrSeal, err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(label)), nil
+}
+
+// Open decrypts a value produced by Seal under the same label, trying each
+// key.
+func (k *Keyring) Open(label string, sealed []byte) ([]byte, error) {
+	for _, aead := range k.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrOpen
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(label)); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrOpen
+}
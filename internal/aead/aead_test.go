@@ -0,0 +1,61 @@
+package aead
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	old, err := New("test", []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.Seal("label", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := New("test", []byte("new"), []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New("other", []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		keyring *Keyring
+		label   string
+		sealed  []byte
+		err     error
+	}{
+		{name: "same keys", keyring: old, label: "label", sealed: sealed},
+		{name: "rotated keys", keyring: rotated, label: "label", sealed: sealed},
+		{name: "other label", keyring: old, label: "other", sealed: sealed, err: ErrOpen},
+		{name: "other info", keyring: other, label: "label", sealed: sealed, err: ErrOpen},
+		{name: "tampered", keyring: old, label: "label",
+			sealed: append(append([]byte{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^1), err: ErrOpen},
+		{name: "truncated", keyring: old, label: "label", sealed: sealed[:4], err: ErrOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.keyring.Open(tc.label, tc.sealed)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "value" {
+				t.Errorf("expected value, got %q", got)
+			}
+		})
+	}
+
+	if _, err := New("test"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected no key error, got %v", err)
+	}
+}
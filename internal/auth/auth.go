@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+var (
+	ErrDuplicateProvider = xerrors.Message("provider already registered")
+	ErrExchange          = xerrors.Message("failed retrieving token from code")
+	ErrUser              = xerrors.Message("failed retrieving user")
+
+	ErrMissingRedirect     = xerrors.Message("can't instanciate, missing redirect")
+	ErrMissingClientID     = xerrors.Message("can't instanciate, missing client ID")
+	ErrMissingClientSecret = xerrors.Message("can't instanciate, missing client secret")
+)
+
+// Provider is an identity provider users can log in with.
+type Provider interface {
+	// Name identifies the provider in routes (/auth/{name}/login) and users.
+	Name() string
+	AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string
+	Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+	// User retrieves the identity behind token, nonce is the value sent with
+	// the authorization request, providers issuing ID tokens must check it.
+	// The email is left empty unless the provider asserts it is verified.
+	User(ctx context.Context, token *oauth2.Token, nonce string) (*types.User, error)
+	// TokenSource returns token as long as it is valid, then refreshes it.
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+}
+
+// Nonce adds the OpenID Connect nonce to the authorization request, providers
+// not supporting it ignore the parameter.
+func Nonce(nonce string) oauth2.AuthCodeOption {
+	return oidc.Nonce(nonce)
+}
+
+// OAuth2 implements the authorization code flow shared by all providers,
+// they only have to embed it and implement User.
+type OAuth2 struct {
+	name   string
+	config *oauth2.Config
+}
+
+// NewOAuth2 validates the client settings of the provider called name.
+func NewOAuth2(name string, config *oauth2.Config) (oa OAuth2, err error) {
+	if config.RedirectURL == "" {
+		err = xerrors.Append(err, ErrMissingRedirect)
+	}
+	if config.ClientID == "" {
+		err = xerrors.Append(err, ErrMissingClientID)
+	}
+	if config.ClientSecret == "" {
+		err = xerrors.Append(err, ErrMissingClientSecret)
+	}
+	if err != nil {
+		return oa, xerrors.New("provider", name, err)
+	}
+	return OAuth2{name: name, config: config}, nil
+}
+
+func (oa OAuth2) Name() string { return oa.name }
+
+func (oa OAuth2) Config() *oauth2.Config { return oa.config }
+
+func (oa OAuth2) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return oa.config.AuthCodeURL(state, opts...)
+}
+
+func (oa OAuth2) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	token, err := oa.config.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrExchange, err)
+	}
+	return token, nil
+}
+
+func (oa OAuth2) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return oa.config.TokenSource(ctx, token)
+}
+
+// Registry holds the configured providers, in registration order.
+type Registry struct {
+	providers map[string]Provider
+	names     []string
+}
+
+func NewRegistry(providers ...Provider) (*Registry, error) {
+	r := &Registry{providers: map[string]Provider{}}
+	for _, provider := range providers {
+		if _, ok := r.providers[provider.Name()]; ok {
+			return nil, xerrors.New(ErrDuplicateProvider, provider.Name())
+		}
+		r.providers[provider.Name()] = provider
+		r.names = append(r.names, provider.Name())
+	}
+	return r, nil
+}
+
+func (r *Registry) Get(name string) (Provider, bool) {
+	if r == nil {
+		return nil, false
+	}
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+	return r.names
+}
+
+type registryKey struct{}
+
+// Middleware makes the registry available to handlers and templates through
+// FromContext.
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), registryKey{}, r)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func FromContext(ctx context.Context) *Registry {
+	r, _ := ctx.Value(registryKey{}).(*Registry)
+	return r
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+const GitLabURL = "https://gitlab.com"
+
+// GitLab logs users in with gitlab.com or a self hosted instance.
+type GitLab struct {
+	OAuth2
+	url string
+}
+
+func NewGitLab(name, url, redirect, id, secret string) (*GitLab, error) {
+	if url == "" {
+		url = GitLabURL
+	}
+	url = strings.TrimSuffix(url, "/")
+	oa, err := NewOAuth2(name, &oauth2.Config{
+		RedirectURL:  redirect,
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"read_user"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  url + "/oauth/authorize",
+			TokenURL: url + "/oauth/token",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GitLab{OAuth2: oa, url: url}, nil
+}
+
+func (g *GitLab) User(ctx context.Context, token *oauth2.Token, _ string) (*types.User, error) {
+	resp, err := g.config.Client(ctx, token).Get(g.url + "/api/v4/user")
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrUser, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.New(ErrUser, resp.Status)
+	}
+	u := struct {
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		Username  string `json:"username"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+		// only set for the authenticated user, once the email is confirmed
+		ConfirmedAt *time.Time `json:"confirmed_at"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return nil, xerrors.WithWrapper(ErrUser, err)
+	}
+	// users are matched by email, an unconfirmed one could be anybody's
+	if u.ConfirmedAt == nil {
+		u.Email = ""
+	}
+	if u.Name == "" {
+		u.Name = u.Username
+	}
+	user := types.NewUser(u.Name, u.Email)
+	user.Avatar, user.Provider = u.AvatarURL, g.Name()
+	user.Subject = strconv.FormatInt(u.ID, 10)
+	return user, nil
+}
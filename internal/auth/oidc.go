@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+const GoogleIssuer = "https://accounts.google.com"
+
+var (
+	ErrDiscovery = xerrors.Message("failed discovering provider")
+	ErrIDToken   = xerrors.Message("invalid id token")
+)
+
+// OIDC logs users in with any OpenID Connect issuer, endpoints are retrieved
+// through discovery and the identity comes from the verified ID token.
+type OIDC struct {
+	OAuth2
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDC(ctx context.Context, name, issuer, redirect, id, secret string, scopes []string) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, xerrors.New("provider", name, ErrDiscovery, err)
+	}
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	oa, err := NewOAuth2(name, &oauth2.Config{
+		RedirectURL:  redirect,
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       scopes,
+		Endpoint:     provider.Endpoint(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDC{OAuth2: oa, verifier: provider.Verifier(&oidc.Config{ClientID: id})}, nil
+}
+
+func NewGoogle(ctx context.Context, name, redirect, id, secret string) (*OIDC, error) {
+	return NewOIDC(ctx, name, GoogleIssuer, redirect, id, secret, nil)
+}
+
+func (o *OIDC) User(ctx context.Context, token *oauth2.Token, nonce string) (*types.User, error) {
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, xerrors.New(ErrIDToken, "missing from token response")
+	}
+	idToken, err := o.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrIDToken, err)
+	}
+	// the token must have been issued for the authorization request of this
+	// browser, not replayed from another one
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, xerrors.New(ErrIDToken, "nonce mismatch")
+	}
+	claims := struct {
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Picture           string `json:"picture"`
+	}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, xerrors.WithWrapper(ErrIDToken, err)
+	}
+	// users are matched by email, an unverified one could be anybody's and a
+	// missing claim asserts nothing
+	if !claims.EmailVerified {
+		claims.Email = ""
+	}
+	if claims.Name == "" {
+		claims.Name = claims.PreferredUsername
+	}
+	user := types.NewUser(claims.Name, claims.Email)
+	user.Avatar, user.Provider, user.Subject = claims.Picture, o.Name(), idToken.Subject
+	return user, nil
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testClientID = "d2s"
+	testKeyID    = "test"
+	testNonce    = "nonce"
+)
+
+// issuer is a minimal OpenID Connect provider serving discovery, keys and a
+// token endpoint returning an ID token with the given claims.
+type issuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &issuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                iss.URL,
+			"authorization_endpoint":                iss.URL + "/authorize",
+			"token_endpoint":                        iss.URL + "/token",
+			"jwks_uri":                              iss.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     iss.sign(t),
+		})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *issuer) sign(t *testing.T) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: iss.key},
+		(&jose.SignerOptions{}).WithHeader("kid", testKeyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{
+		"iss":   iss.URL,
+		"aud":   testClientID,
+		"sub":   "1234",
+		"nonce": testNonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range iss.claims {
+		claims[k] = v
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := object.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestOIDCUser(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		claims map[string]any
+		user   string
+		email  string
+		err    error
+	}{
+		{name: "verified",
+			claims: map[string]any{"name": "Jane", "email": "jane@example.com", "email_verified": true},
+			user:   "Jane", email: "jane@example.com"},
+		{name: "unverified email",
+			claims: map[string]any{"name": "Jane", "email": "jane@example.com", "email_verified": false},
+			user:   "Jane"},
+		{name: "username fallback",
+			claims: map[string]any{"preferred_username": "jane", "email": "jane@example.com"},
+			user:   "jane"},
+		{name: "wrong audience",
+			claims: map[string]any{"aud": "someone-else"},
+			err:    ErrIDToken},
+		{name: "nonce mismatch",
+			claims: map[string]any{"nonce": "replayed"},
+			err:    ErrIDToken},
+		{name: "expired",
+			claims: map[string]any{"exp": time.Now().Add(-time.Hour).Unix()},
+			err:    ErrIDToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			iss := newIssuer(t)
+			iss.claims = tc.claims
+
+			provider, err := NewOIDC(ctx, "test", iss.URL, "http://localhost/auth/test/callback",
+				testClientID, "secret", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := provider.Exchange(ctx, "code")
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, err := provider.User(ctx, token, testNonce)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Name != tc.user || user.Email != tc.email || user.Provider != "test" || user.Subject != "1234" {
+				t.Errorf("unexpected user %+v", user)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	_, err := NewOIDC(context.Background(), "test", server.URL, "http://localhost/callback",
+		testClientID, "secret", nil)
+	if !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("expected %v, got %v", ErrDiscovery, err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	oa, err := NewOAuth2("test", &oauth2.Config{RedirectURL: "/callback", ClientID: "id", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitlab, err := NewGitLab("gitlab", "", "/callback", "id", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegistry(gitlab, gitlab); !errors.Is(err, ErrDuplicateProvider) {
+		t.Fatalf("expected %v, got %v", ErrDuplicateProvider, err)
+	}
+	registry, err := NewRegistry(gitlab, &OIDC{OAuth2: oa})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := registry.Names(); len(names) != 2 || names[0] != "gitlab" || names[1] != "test" {
+		t.Errorf("unexpected names %v", names)
+	}
+	if _, ok := registry.Get("github"); ok {
+		t.Error("unexpected github provider")
+	}
+	if _, ok := (*Registry)(nil).Get("gitlab"); ok {
+		t.Error("nil registry should not hold providers")
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrToken   = xerrors.Message("failed retrieving stored token")
+	ErrRefresh = xerrors.Message("failed refreshing token")
+)
+
+// TokenStore persists the tokens granted to users, data.DB implements it.
+type TokenStore interface {
+	GetToken(ctx context.Context, userID int64, provider string) (*oauth2.Token, error)
+	SaveToken(ctx context.Context, userID int64, provider string, token *oauth2.Token) error
+}
+
+// tokenSource refreshes the stored token of a user through its provider and
+// writes refreshed tokens back, so the next requests start from them.
+type tokenSource struct {
+	ctx      context.Context
+	store    TokenStore
+	userID   int64
+	provider string
+
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	last   string
+}
+
+// NewTokenSource loads the token the user was granted by provider. The error
+// of the store is returned as is when no token is stored, so callers can tell
+// the user has to log in again.
+func NewTokenSource(ctx context.Context, store TokenStore, provider Provider, userID int64) (oauth2.TokenSource, error) {
+	token, err := store.GetToken(ctx, userID, provider.Name())
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrToken, err)
+	}
+	return &tokenSource{ctx: ctx, store: store, userID: userID, provider: provider.Name(),
+		source: provider.TokenSource(ctx, token), last: token.AccessToken}, nil
+}
+
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token, err := ts.source.Token()
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrRefresh, err)
+	}
+	if token.AccessToken != ts.last {
+		if err := ts.store.SaveToken(ts.ctx, ts.userID, ts.provider, token); err != nil {
+			return nil, xerrors.WithWrapper(ErrRefresh, err)
+		}
+		ts.last = token.AccessToken
+	}
+	return token, nil
+}
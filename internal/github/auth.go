@@ -2,65 +2,72 @@ package github
 
 import (
 	"context"
+	"net/url"
 
 	"github.com/mdobak/go-xerrors"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
+
+	"github.com/platipy-io/d2s/internal/auth"
+	"github.com/platipy-io/d2s/types"
 )
 
-var (
-	// Scopes: OAuth 2.0 scopes provide a way to limit the amount of access that is granted to an access token.
-	oauthConfig *oauth2.Config
-	bypassToken string
+const ProviderName = "github"
 
-	ErrMissingRedirect     = xerrors.Message("can't instanciate, missing redirect")
-	ErrMissingClientID     = xerrors.Message("can't instanciate, missing client ID")
-	ErrMissingClientSecret = xerrors.Message("can't instanciate, missing redirect")
-	ErrMissingBypassToken  = xerrors.Message("can't instanciate, missing token")
-)
+var ErrMissingBypassToken = xerrors.Message("can't instanciate, missing token")
 
-func InitOAuth(redirect, id, secret string) (err error) {
-	if oauthConfig != nil {
-		panic("oauth config already initialized")
-	}
-	if redirect == "" {
-		err = xerrors.Append(err, ErrMissingRedirect)
-	}
-	if id == "" {
-		err = xerrors.Append(err, ErrMissingClientID)
-	}
-	if secret == "" {
-		err = xerrors.Append(err, ErrMissingClientSecret)
-	}
-	if err != nil {
-		return err
-	}
-	oauthConfig = &oauth2.Config{
+// Provider logs users in through a GitHub OAuth application.
+type Provider struct {
+	auth.OAuth2
+}
+
+func NewProvider(redirect, id, secret string) (*Provider, error) {
+	oa, err := auth.NewOAuth2(ProviderName, &oauth2.Config{
 		RedirectURL:  redirect,
 		ClientID:     id,
 		ClientSecret: secret,
-		Scopes:       []string{"read:user", "user:email"},
-		Endpoint:     github.Endpoint,
+		// Scopes: OAuth 2.0 scopes provide a way to limit the amount of access that is granted to an access token.
+		Scopes:   []string{"read:user", "user:email"},
+		Endpoint: github.Endpoint,
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &Provider{OAuth2: oa}, nil
 }
 
-func InitBypass(token string) error {
+func (p *Provider) User(ctx context.Context, token *oauth2.Token, _ string) (*types.User, error) {
+	return User(ctx, oauth2.StaticTokenSource(token))
+}
+
+// Bypass skips the OAuth dance and logs in with a static token, it is meant
+// for development only. The authorization URL leads straight to callback.
+type Bypass struct {
+	token    string
+	callback string
+}
+
+func NewBypass(token, callback string) (*Bypass, error) {
 	if token == "" {
-		return ErrMissingBypassToken
+		return nil, ErrMissingBypassToken
 	}
-	bypassToken = token
-	return nil
+	return &Bypass{token: token, callback: callback}, nil
 }
 
-func AuthCodeURL(state string) string {
-	return oauthConfig.AuthCodeURL(state)
+func (b *Bypass) Name() string { return ProviderName }
+
+func (b *Bypass) AuthCodeURL(state string, _ ...oauth2.AuthCodeOption) string {
+	return b.callback + "?" + url.Values{"state": {state}, "code": {"bypass"}}.Encode()
 }
 
-func Exchange(ctx context.Context, code string) (string, error) {
-	token, err := oauthConfig.Exchange(ctx, code)
-	if err != nil {
-		return "", xerrors.New("failed retrieving token from code", err)
-	}
-	return token.AccessToken, nil
+func (b *Bypass) Exchange(_ context.Context, _ string, _ ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: b.token, TokenType: "Bearer"}, nil
+}
+
+func (b *Bypass) TokenSource(_ context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(token)
+}
+
+func (b *Bypass) User(ctx context.Context, token *oauth2.Token, _ string) (*types.User, error) {
+	return User(ctx, oauth2.StaticTokenSource(token))
 }
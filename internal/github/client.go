@@ -3,61 +3,161 @@ package github
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v68/github"
 	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
 	"github.com/platipy-io/d2s/types"
 )
 
 var ErrClient = xerrors.Message("github API call failed")
 
-var httpClient = github.NewClient(&http.Client{
-	Timeout: 5 * time.Second,
-	Transport: &http.Transport{
-		MaxIdleConnsPerHost: 5,
-	},
-})
+var transport = &http.Transport{
+	MaxIdleConnsPerHost: 5,
+}
+
+// limiter is shared by the clients, the rate limits of a token are known
+// whichever request hit them.
+var limiter = newRateLimitTransport(transport, DefaultRateLimitWait)
 
 type Client struct {
 	c *github.Client
 }
 
-func NewClient(token string) *Client {
-	return &Client{c: httpClient.WithAuthToken(token)}
-}
-
-func UserBypass(ctx context.Context) (*types.User, error) {
-	return User(ctx, bypassToken)
+// NewClient authenticates calls with the tokens of source, refreshing them
+// when the source supports it. Calls fail with a RateLimitError while the
+// token is rate limited.
+func NewClient(source oauth2.TokenSource) *Client {
+	return &Client{c: github.NewClient(&http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &oauth2.Transport{Source: source, Base: limiter},
+	})}
 }
 
-func User(ctx context.Context, token string) (*types.User, error) {
-	user, _, err := NewClient(token).c.Users.Get(ctx, "")
+func User(ctx context.Context, source oauth2.TokenSource) (*types.User, error) {
+	user, _, err := NewClient(source).c.Users.Get(ctx, "")
 	if err != nil {
 		return nil, xerrors.New(ErrClient, err)
 	}
-	return types.NewUser(user.GetName(), user.GetEmail(), token), nil
+	// GitHub only lets verified addresses be the public email of a profile
+	u := types.NewUser(user.GetName(), user.GetEmail())
+	u.Avatar, u.Provider = user.GetAvatarURL(), ProviderName
+	u.Subject = strconv.FormatInt(user.GetID(), 10)
+	return u, nil
 }
 
-var ErrStarred = xerrors.Message("Github API starred listing failed")
+var (
+	ErrStarred     = xerrors.Message("Github API starred listing failed")
+	ErrNotModified = xerrors.Message("not modified")
+	ErrBaseURL     = xerrors.Message("invalid API base URL")
+)
 
-func Starred(ctx context.Context, user *types.User) ([]*types.Repository, error) {
-	opts := github.ActivityListStarredOptions{ListOptions: github.ListOptions{}}
-	starred, _, err := NewClient(user.Token).c.Activity.ListStarred(ctx, "", &opts)
+// SetBaseURL makes the client call the API at base, a GitHub Enterprise
+// instance or a test server.
+func (c *Client) SetBaseURL(base string) error {
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + "/")
 	if err != nil {
-		return nil, xerrors.WithWrapper(ErrStarred, err)
-	}
-	repos := make([]*types.Repository, len(starred))
-	for i, repo := range starred {
-		r := types.Repository{
-			ID:          *repo.Repository.ID,
-			Owner:       *repo.Repository.Owner.Login,
-			Name:        *repo.Repository.Name,
-			Description: *repo.Repository.Description,
-			Language:    *repo.Repository.Language,
-			LastUpdated: repo.Repository.UpdatedAt.Time,
+		return xerrors.New(ErrBaseURL, base, err)
+	}
+	c.c.BaseURL = u
+	return nil
+}
+
+const (
+	// DefaultPerPage is the largest page size the API allows.
+	DefaultPerPage = 100
+	// DefaultMaxStarred bounds the listing of users starring a lot.
+	DefaultMaxStarred = 1000
+)
+
+type starredConfig struct {
+	perPage  int
+	maxItems int
+}
+
+// StarredOption applies a configuration option value to a Starred listing.
+type StarredOption interface {
+	apply(starredConfig) starredConfig
+}
+
+type StarredOptionFunc func(starredConfig) starredConfig
+
+func (fn StarredOptionFunc) apply(c starredConfig) starredConfig {
+	return fn(c)
+}
+
+// WithPerPage sets how many repositories are requested per page.
+func WithPerPage(perPage int) StarredOption {
+	return StarredOptionFunc(func(sc starredConfig) starredConfig {
+		sc.perPage = perPage
+		return sc
+	})
+}
+
+// WithMaxItems stops the listing once that many repositories were fetched.
+func WithMaxItems(maxItems int) StarredOption {
+	return StarredOptionFunc(func(sc starredConfig) starredConfig {
+		sc.maxItems = maxItems
+		return sc
+	})
+}
+
+// Starred lists the repositories starred by the user, following the pages
+// until the last one or the configured maximum. When etag is set the first
+// request is conditional and ErrNotModified is returned if nothing changed
+// since, the ETag to send next time is returned otherwise.
+func (c *Client) Starred(ctx context.Context, etag string, opts ...StarredOption) ([]*types.Repository, string, error) {
+	sc := starredConfig{perPage: DefaultPerPage, maxItems: DefaultMaxStarred}
+	for _, opt := range opts {
+		sc = opt.apply(sc)
+	}
+	var repos []*types.Repository
+	for page := 1; page != 0 && len(repos) < sc.maxItems; {
+		if err := ctx.Err(); err != nil {
+			return nil, "", xerrors.WithWrapper(ErrStarred, err)
+		}
+		starred, resp, err := c.starredPage(ctx, page, sc.perPage, etag)
+		if err != nil {
+			return nil, "", err
 		}
-		repos[i] = &r
+		if page == 1 {
+			etag = resp.Header.Get("ETag")
+		}
+		repos = append(repos, newStarred(starred)...)
+		page = resp.NextPage
+	}
+	if len(repos) > sc.maxItems {
+		repos = repos[:sc.maxItems]
+	}
+	return repos, etag, nil
+}
+
+// starredPage fetches a page of the listing, only the first one is sent with
+// etag: the following ones are fetched in full anyway.
+func (c *Client) starredPage(ctx context.Context, page, perPage int, etag string) ([]*github.StarredRepository, *github.Response, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	req, err := c.c.NewRequest(http.MethodGet, "user/starred?"+query.Encode(), nil)
+	if err != nil {
+		return nil, nil, xerrors.WithWrapper(ErrStarred, err)
+	}
+	// wraps each repository with the star creation date, as ListStarred does
+	req.Header.Set("Accept", "application/vnd.github.v3.star+json")
+	if etag != "" && page == 1 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	var starred []*github.StarredRepository
+	resp, err := c.c.Do(ctx, req, &starred)
+	if resp != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, nil, ErrNotModified
+	} else if err != nil {
+		return nil, nil, xerrors.WithWrapper(ErrStarred, err)
 	}
-	return repos, nil
+	return starred, resp, nil
 }
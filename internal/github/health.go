@@ -0,0 +1,36 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+	"github.com/mdobak/go-xerrors"
+)
+
+var ErrUnreachable = xerrors.Message("github API unreachable")
+
+// anonymous calls the API without token, its quota is the one of the address
+// of the server.
+var anonymous = github.NewClient(&http.Client{Timeout: 5 * time.Second, Transport: transport})
+
+// Reachable checks the API answers through the rate limit endpoint, which
+// does not count against the quota. Only the server errors of GitHub fail it:
+// a rate limited answer still means the API is up.
+func Reachable(ctx context.Context) error {
+	req, err := anonymous.NewRequest(http.MethodGet, "rate_limit", nil)
+	if err != nil {
+		return xerrors.New(ErrUnreachable, err)
+	}
+	resp, err := anonymous.BareDo(ctx, req)
+	if resp == nil {
+		return xerrors.New(ErrUnreachable, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return xerrors.New(ErrUnreachable, strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultRateLimitWait is how long a call waits for the quota of its token
+	// to come back before failing with a RateLimitError.
+	DefaultRateLimitWait = time.Second
+	// secondary rate limits without Retry-After are retried after a backoff
+	// doubling on each hit, as GitHub recommends.
+	minSecondaryBackoff = time.Minute
+	maxSecondaryBackoff = 15 * time.Minute
+)
+
+var ErrRateLimited = xerrors.Message("github API rate limit exceeded")
+
+// RateLimitError is returned instead of calling the API while the token is
+// rate limited, Reset tells when calls are allowed again. It matches
+// ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	Reset     time.Time
+	Secondary bool
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s (%s) until %s", ErrRateLimited, e.kind(), e.Reset.Format(time.RFC3339))
+}
+
+func (e *RateLimitError) kind() string {
+	if e.Secondary {
+		return "secondary"
+	}
+	return "primary"
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return errors.Is(ErrRateLimited, target)
+}
+
+var (
+	// the tokens are not told apart, there is one per user: the gauges follow
+	// the token closest to its limit
+	rateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_rate_limit_remaining",
+		Help: "Requests left in the current window of the most used token, partitioned by resource.",
+	}, []string{"resource"})
+	rateLimitLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_rate_limit_limit",
+		Help: "Requests allowed per window of the most used token, partitioned by resource.",
+	}, []string{"resource"})
+	rateLimitReset = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_rate_limit_reset_timestamp_seconds",
+		Help: "When the current window of the most used token ends, partitioned by resource.",
+	}, []string{"resource"})
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_rate_limited_total",
+		Help: "How many calls were rejected by GitHub or held back, partitioned by kind (primary or secondary).",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitRemaining, rateLimitLimit, rateLimitReset, rateLimited)
+}
+
+// rateState is kept for the tokens which are, or recently were, rate limited.
+type rateState struct {
+	until     time.Time
+	secondary bool
+	backoff   time.Duration
+}
+
+// quota is the window last reported for a token and a resource.
+type quota struct {
+	remaining int
+	limit     int
+	reset     time.Time
+}
+
+// rateLimitTransport tracks the rate limits reported by GitHub for each token
+// and holds calls back until they reset: the call waits when the reset is
+// within maxWait and fails with a RateLimitError otherwise.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	maxWait time.Duration
+
+	mu     sync.Mutex
+	states map[string]*rateState
+	// by resource then token, for the gauges
+	quotas map[string]map[string]quota
+}
+
+func newRateLimitTransport(base http.RoundTripper, maxWait time.Duration) *rateLimitTransport {
+	return &rateLimitTransport{base: base, maxWait: maxWait,
+		states: map[string]*rateState{}, quotas: map[string]map[string]quota{}}
+}
+
+// tokenKey identifies the token of the request without keeping it around.
+func tokenKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Header.Get("Authorization")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := tokenKey(req)
+	if err := t.wait(req.Context(), key); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.update(key, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *rateLimitTransport) wait(ctx context.Context, key string) error {
+	t.mu.Lock()
+	state, ok := t.states[key]
+	var until time.Time
+	var secondary bool
+	if ok {
+		until, secondary = state.until, state.secondary
+	}
+	t.mu.Unlock()
+
+	delay := time.Until(until)
+	if delay <= 0 {
+		return nil
+	} else if delay > t.maxWait {
+		err := &RateLimitError{Reset: until, Secondary: secondary}
+		rateLimited.WithLabelValues(err.kind()).Inc()
+		return err
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// update records the limits reported by resp, a RateLimitError is returned
+// when resp is a rejection because of them.
+func (t *rateLimitTransport) update(key string, resp *http.Response) error {
+	now := time.Now()
+	remaining, hasRemaining := headerInt(resp.Header, "X-RateLimit-Remaining")
+	reset, _ := headerInt(resp.Header, "X-RateLimit-Reset")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if hasRemaining {
+		resource := resp.Header.Get("X-RateLimit-Resource")
+		if resource == "" {
+			resource = "core"
+		}
+		limit, _ := headerInt(resp.Header, "X-RateLimit-Limit")
+		if t.quotas[resource] == nil {
+			t.quotas[resource] = map[string]quota{}
+		}
+		t.quotas[resource][key] = quota{remaining: remaining, limit: limit, reset: time.Unix(int64(reset), 0)}
+	}
+	defer t.prune(now)
+	state, ok := t.states[key]
+	if !ok {
+		state = &rateState{}
+	}
+	rejected := resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests
+	switch retryAfter, hasRetryAfter := headerInt(resp.Header, "Retry-After"); {
+	case rejected && hasRetryAfter:
+		state.until, state.secondary = now.Add(time.Duration(retryAfter)*time.Second), true
+	case hasRemaining && remaining == 0:
+		// rejected, or the call consumed the last request of the window
+		state.until, state.secondary = time.Unix(int64(reset), 0), false
+	case resp.StatusCode == http.StatusTooManyRequests || rejected && isSecondary(resp):
+		state.backoff = min(max(2*state.backoff, minSecondaryBackoff), maxSecondaryBackoff)
+		state.until, state.secondary = now.Add(state.backoff), true
+	case rejected:
+		// a genuine permission error, it tells nothing about the limits
+		return nil
+	default:
+		delete(t.states, key)
+		return nil
+	}
+	t.states[key] = state
+	if !rejected {
+		return nil
+	}
+	err := &RateLimitError{Reset: state.until, Secondary: state.secondary}
+	rateLimited.WithLabelValues(err.kind()).Inc()
+	return err
+}
+
+// prune forgets the windows which are over and the tokens not held back for
+// long enough that their backoff does not matter anymore, then exports the
+// most used token of each resource. The series of the resources left without
+// any token are deleted. t.mu must be held.
+func (t *rateLimitTransport) prune(now time.Time) {
+	for key, state := range t.states {
+		if now.Sub(state.until) > maxSecondaryBackoff {
+			delete(t.states, key)
+		}
+	}
+	for resource, tokens := range t.quotas {
+		var lowest *quota
+		for key, q := range tokens {
+			if !q.reset.After(now) {
+				delete(tokens, key)
+			} else if lowest == nil || q.remaining < lowest.remaining {
+				lowest = &q
+			}
+		}
+		if lowest == nil {
+			delete(t.quotas, resource)
+			rateLimitRemaining.DeleteLabelValues(resource)
+			rateLimitLimit.DeleteLabelValues(resource)
+			rateLimitReset.DeleteLabelValues(resource)
+			continue
+		}
+		rateLimitRemaining.WithLabelValues(resource).Set(float64(lowest.remaining))
+		rateLimitLimit.WithLabelValues(resource).Set(float64(lowest.limit))
+		rateLimitReset.WithLabelValues(resource).Set(float64(lowest.reset.Unix()))
+	}
+}
+
+// isSecondary tells secondary rate limits, only reported in the message, from
+// other rejections. The body is restored for the caller.
+func isSecondary(resp *http.Response) bool {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	return err == nil && bytes.Contains(bytes.ToLower(body), []byte("secondary rate limit"))
+}
+
+func headerInt(header http.Header, key string) (int, bool) {
+	value, err := strconv.Atoi(header.Get(key))
+	return value, err == nil
+}
@@ -0,0 +1,227 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/oauth2"
+)
+
+// limitedAPI answers with the headers set by respond, and counts the calls
+// reaching it.
+func limitedAPI(t *testing.T, respond func(w http.ResponseWriter, call int32)) (*Client, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, calls.Add(1))
+	}))
+	t.Cleanup(srv.Close)
+
+	// each test gets its own token, the limits are tracked per token
+	source := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: t.Name()})
+	client := NewClient(source)
+	if err := client.SetBaseURL(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	return client, &calls
+}
+
+func starred(client *Client) error {
+	_, _, err := client.Starred(context.Background(), "")
+	return err
+}
+
+func ok(w http.ResponseWriter, remaining int, reset time.Time) {
+	w.Header().Set("X-RateLimit-Limit", "5000")
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	w.Header().Set("X-RateLimit-Resource", "core")
+	w.Write([]byte("[]"))
+}
+
+func TestRateLimitPrimary(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	client, calls := limitedAPI(t, func(w http.ResponseWriter, call int32) {
+		if call == 1 {
+			ok(w, 1, reset)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		http.Error(w, `{"message": "API rate limit exceeded"}`, http.StatusForbidden)
+	})
+
+	if err := starred(client); err != nil {
+		t.Fatal(err)
+	}
+
+	var rateErr *RateLimitError
+	if err := starred(client); !errors.As(err, &rateErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if rateErr.Secondary || rateErr.Reset.Unix() != reset.Unix() {
+		t.Errorf("unexpected rate limit %+v", rateErr)
+	}
+	// fails fast until the reset
+	if err := starred(client); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls to reach the API, got %d", calls.Load())
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	client, calls := limitedAPI(t, func(w http.ResponseWriter, call int32) {
+		if call == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"message": "slow down"}`, http.StatusTooManyRequests)
+			return
+		}
+		ok(w, 4000, time.Now().Add(time.Hour))
+	})
+
+	var rateErr *RateLimitError
+	if err := starred(client); !errors.As(err, &rateErr) || !rateErr.Secondary {
+		t.Fatalf("expected secondary rate limit, got %v", err)
+	}
+	// the reset is within the wait, the call is held back then goes through
+	start := time.Now()
+	if err := starred(client); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expected the call to wait for the reset, took %s", elapsed)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls to reach the API, got %d", calls.Load())
+	}
+}
+
+func TestRateLimitSecondaryBackoff(t *testing.T) {
+	client, _ := limitedAPI(t, func(w http.ResponseWriter, call int32) {
+		http.Error(w, `{"message": "You have exceeded a secondary rate limit."}`, http.StatusForbidden)
+	})
+
+	var rateErr *RateLimitError
+	if err := starred(client); !errors.As(err, &rateErr) || !rateErr.Secondary {
+		t.Fatalf("expected secondary rate limit, got %v", err)
+	}
+	if wait := time.Until(rateErr.Reset); wait < 50*time.Second || wait > minSecondaryBackoff {
+		t.Errorf("expected a backoff of %s, got %s", minSecondaryBackoff, wait)
+	}
+}
+
+func TestRateLimitPermissionDenied(t *testing.T) {
+	client, calls := limitedAPI(t, func(w http.ResponseWriter, call int32) {
+		w.Header().Set("X-RateLimit-Remaining", "4000")
+		http.Error(w, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+	})
+
+	for range 2 {
+		if err := starred(client); err == nil || errors.Is(err, ErrRateLimited) {
+			t.Fatalf("expected a plain API error, got %v", err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls to reach the API, got %d", calls.Load())
+	}
+}
+
+func TestRateLimitBodyRestored(t *testing.T) {
+	message := `{"message": "Must have admin rights to Repository."}`
+	transport := newRateLimitTransport(roundTripper(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{},
+			Body: io.NopCloser(strings.NewReader(message))}, nil
+	}), 0)
+	req := httptest.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	if string(content) != message {
+		t.Errorf("expected body %q, got %q", message, content)
+	}
+}
+
+// gauge returns the value of the series of the resource, and whether it
+// exists.
+func gauge(t *testing.T, vec *prometheus.GaugeVec, resource string) (float64, bool) {
+	t.Helper()
+	metrics := make(chan prometheus.Metric, 16)
+	vec.Collect(metrics)
+	close(metrics)
+	for m := range metrics {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if metric.GetLabel()[0].GetValue() == resource {
+			return metric.GetGauge().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func TestRateLimitMetrics(t *testing.T) {
+	// a resource of its own, the gauges are shared with the other tests
+	const resource = "metrics_test"
+	var (
+		remaining = map[string]string{"Bearer a": "40", "Bearer b": "10"}
+		reset     = time.Now().Add(time.Hour)
+	)
+	transport := newRateLimitTransport(roundTripper(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set("X-RateLimit-Limit", "50")
+		header.Set("X-RateLimit-Remaining", remaining[req.Header.Get("Authorization")])
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		header.Set("X-RateLimit-Resource", resource)
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody}, nil
+	}), 0)
+	call := func(token string) {
+		req := httptest.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+		req.Header.Set("Authorization", token)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call("Bearer a")
+	call("Bearer b")
+	call("Bearer a")
+	if got, _ := gauge(t, rateLimitRemaining, resource); got != 10 {
+		t.Errorf("expected the remaining of the most used token, got %v", got)
+	}
+	if got, _ := gauge(t, rateLimitLimit, resource); got != 50 {
+		t.Errorf("expected a limit of 50, got %v", got)
+	}
+
+	// once the windows are over the tokens and the series are dropped
+	reset = time.Now().Add(-time.Second)
+	call("Bearer a")
+	if got, _ := gauge(t, rateLimitRemaining, resource); got != 10 {
+		t.Errorf("expected the remaining of the token left, got %v", got)
+	}
+	call("Bearer b")
+	if _, ok := gauge(t, rateLimitRemaining, resource); ok || len(transport.quotas) != 0 {
+		t.Errorf("expected the series to be deleted, got %v", transport.quotas)
+	}
+	if _, ok := gauge(t, rateLimitReset, resource); ok {
+		t.Error("expected the reset series to be deleted")
+	}
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (fn roundTripper) RoundTrip(req *http.Request) (*http.Response, error) { return fn(req) }
@@ -0,0 +1,40 @@
+package github
+
+import (
+	"github.com/google/go-github/v68/github"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+// NewRepository maps a repository of the API or of a webhook payload, any
+// field may be missing (no description, no detected language...) so only the
+// nil safe accessors are used.
+func NewRepository(repo *github.Repository) *types.Repository {
+	return &types.Repository{
+		ID:          repo.GetID(),
+		Owner:       repo.GetOwner().GetLogin(),
+		Name:        repo.GetName(),
+		Description: repo.GetDescription(),
+		Language:    repo.GetLanguage(),
+		LastUpdated: repo.GetUpdatedAt().Time,
+		HTMLURL:     repo.GetHTMLURL(),
+		Stars:       repo.GetStargazersCount(),
+		Forks:       repo.GetForksCount(),
+		Topics:      repo.Topics,
+		Archived:    repo.GetArchived(),
+		Fork:        repo.GetFork(),
+	}
+}
+
+// newStarred maps a page of the starred listing, entries without repository
+// are skipped.
+func newStarred(starred []*github.StarredRepository) []*types.Repository {
+	repos := make([]*types.Repository, 0, len(starred))
+	for _, star := range starred {
+		if star.GetRepository() == nil {
+			continue
+		}
+		repos = append(repos, NewRepository(star.GetRepository()))
+	}
+	return repos
+}
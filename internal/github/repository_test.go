@@ -0,0 +1,134 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/types"
+)
+
+func fixture(t *testing.T, name string, v any) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func date(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var (
+	goRepo = &types.Repository{
+		ID: 23096959, Owner: "golang", Name: "go",
+		Description: "The Go programming language", Language: "Go",
+		LastUpdated: date("2024-11-20T09:12:44Z"),
+		HTMLURL:     "https://github.com/golang/go", Stars: 124512, Forks: 17689,
+		Topics: []string{"go", "golang", "language", "programming-language"},
+	}
+	// no description, no detected language
+	gitignoreRepo = &types.Repository{
+		ID: 5483330, Owner: "github", Name: "gitignore",
+		LastUpdated: date("2024-11-21T02:10:51Z"),
+		HTMLURL:     "https://github.com/github/gitignore", Stars: 162834, Forks: 82240,
+		Topics: []string{},
+	}
+	// archived fork
+	forkRepo = &types.Repository{
+		ID: 1062897, Owner: "IxDay", Name: "gorilla-mux",
+		Description: "A powerful HTTP router and URL matcher for building Go web servers",
+		Language:    "Go", LastUpdated: date("2023-01-09T22:17:05Z"),
+		HTMLURL: "https://github.com/IxDay/gorilla-mux", Stars: 3,
+		Archived: true, Fork: true,
+	}
+)
+
+func TestNewStarred(t *testing.T) {
+	for _, test := range []struct {
+		fixture  string
+		expected []*types.Repository
+	}{
+		{"starred.json", []*types.Repository{goRepo, gitignoreRepo, forkRepo}},
+		// entries trimmed down to the identifier, or without repository
+		{"starred_partial.json", []*types.Repository{{ID: 42, Name: "minimal"}}},
+	} {
+		t.Run(test.fixture, func(t *testing.T) {
+			var starred []*github.StarredRepository
+			fixture(t, test.fixture, &starred)
+			repos := newStarred(starred)
+			if len(repos) != len(test.expected) {
+				t.Fatalf("expected %d repositories, got %d", len(test.expected), len(repos))
+			}
+			for i, repo := range repos {
+				if !reflect.DeepEqual(repo, test.expected[i]) {
+					t.Errorf("repository %d:\nexpected %+v\ngot      %+v", i, test.expected[i], repo)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRepository(t *testing.T) {
+	expected := *forkRepo
+	expected.Topics = []string{}
+
+	var repo github.Repository
+	fixture(t, "repository_fork.json", &repo)
+	if got := NewRepository(&repo); !reflect.DeepEqual(got, &expected) {
+		t.Errorf("expected %+v\ngot      %+v", &expected, got)
+	}
+}
+
+func TestStarred(t *testing.T) {
+	var starred []*github.StarredRepository
+	content := fixture(t, "starred.json", &starred)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/vnd.github.v3.star+json" {
+			http.Error(w, "missing star media type", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"recorded"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"recorded"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	client := NewClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}))
+	if err := client.SetBaseURL(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	repos, etag, err := client.Starred(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != `"recorded"` || !reflect.DeepEqual(repos, []*types.Repository{goRepo, gitignoreRepo, forkRepo}) {
+		t.Errorf("unexpected listing %q %+v", etag, repos)
+	}
+	if _, _, err := client.Starred(context.Background(), etag); !errors.Is(err, ErrNotModified) {
+		t.Errorf("expected not modified, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+// Code generated by go run ./gen; DO NOT EDIT.
+
+package linguist
+
+var languages = map[string]Language{
+	"assembly":         {Name: "Assembly", Color: "#6E4C13", Aliases: []string{"asm", "nasm"}, Extensions: []string{".asm", ".a51", ".i", ".inc", ".nas", ".nasm", ".s"}},
+	"c":                {Name: "C", Color: "#555555", Extensions: []string{".c", ".cats", ".h", ".idc"}},
+	"c#":               {Name: "C#", Color: "#178600", Aliases: []string{"csharp", "cake", "cakescript"}, Extensions: []string{".cs", ".cake", ".csx", ".linq"}},
+	"c++":              {Name: "C++", Color: "#f34b7d", Aliases: []string{"cpp"}, Extensions: []string{".cpp", ".c++", ".cc", ".cp", ".cxx", ".h", ".h++", ".hh", ".hpp", ".hxx"}},
+	"clojure":          {Name: "Clojure", Color: "#db5855", Extensions: []string{".clj", ".cljc", ".cljs", ".edn"}},
+	"crystal":          {Name: "Crystal", Color: "#000100", Extensions: []string{".cr"}},
+	"css":              {Name: "CSS", Color: "#663399", Extensions: []string{".css"}},
+	"dart":             {Name: "Dart", Color: "#00B4AB", Extensions: []string{".dart"}},
+	"dockerfile":       {Name: "Dockerfile", Color: "#384d54", Aliases: []string{"Containerfile"}, Extensions: []string{".dockerfile"}},
+	"elixir":           {Name: "Elixir", Color: "#6e4a7e", Extensions: []string{".ex", ".exs"}},
+	"elm":              {Name: "Elm", Color: "#60B5CC", Extensions: []string{".elm"}},
+	"emacs lisp":       {Name: "Emacs Lisp", Color: "#c065db", Aliases: []string{"elisp", "emacs"}, Extensions: []string{".el", ".emacs"}},
+	"erlang":           {Name: "Erlang", Color: "#B83998", Extensions: []string{".erl", ".hrl"}},
+	"f#":               {Name: "F#", Color: "#b845fc", Aliases: []string{"fsharp"}, Extensions: []string{".fs", ".fsi", ".fsx"}},
+	"go":               {Name: "Go", Color: "#00ADD8", Aliases: []string{"golang"}, Extensions: []string{".go"}},
+	"groovy":           {Name: "Groovy", Color: "#4298b8", Extensions: []string{".groovy", ".gradle"}},
+	"haskell":          {Name: "Haskell", Color: "#5e5086", Extensions: []string{".hs", ".hs-boot", ".hsc"}},
+	"hcl":              {Name: "HCL", Color: "#844FBA", Aliases: []string{"HashiCorp Configuration Language", "terraform"}, Extensions: []string{".hcl", ".tf", ".tfvars"}},
+	"html":             {Name: "HTML", Color: "#e34c26", Aliases: []string{"xhtml"}, Extensions: []string{".html", ".htm", ".xht", ".xhtml"}},
+	"java":             {Name: "Java", Color: "#b07219", Extensions: []string{".java", ".jav"}},
+	"javascript":       {Name: "JavaScript", Color: "#f1e05a", Aliases: []string{"js", "node"}, Extensions: []string{".js", ".cjs", ".jsx", ".mjs"}},
+	"json":             {Name: "JSON", Color: "#292929", Aliases: []string{"geojson", "jsonl", "topojson"}, Extensions: []string{".json", ".geojson", ".jsonl"}},
+	"julia":            {Name: "Julia", Color: "#a270ba", Extensions: []string{".jl"}},
+	"jupyter notebook": {Name: "Jupyter Notebook", Color: "#DA5B0B", Aliases: []string{"IPython Notebook"}, Extensions: []string{".ipynb"}},
+	"kotlin":           {Name: "Kotlin", Color: "#A97BFF", Extensions: []string{".kt", ".ktm", ".kts"}},
+	"lua":              {Name: "Lua", Color: "#000080", Extensions: []string{".lua", ".rockspec"}},
+	"makefile":         {Name: "Makefile", Color: "#427819", Aliases: []string{"bsdmake", "make", "mf"}, Extensions: []string{".mak", ".make", ".mk"}},
+	"markdown":         {Name: "Markdown", Color: "#083fa1", Aliases: []string{"md", "pandoc"}, Extensions: []string{".md", ".markdown", ".mdown"}},
+	"nim":              {Name: "Nim", Color: "#ffc200", Extensions: []string{".nim", ".nimble"}},
+	"nix":              {Name: "Nix", Color: "#7e7eff", Aliases: []string{"nixos"}, Extensions: []string{".nix"}},
+	"objective-c":      {Name: "Objective-C", Color: "#438eff", Aliases: []string{"obj-c", "objc", "objectivec"}, Extensions: []string{".m", ".h"}},
+	"ocaml":            {Name: "OCaml", Color: "#ef7a08", Extensions: []string{".ml", ".mli"}},
+	"perl":             {Name: "Perl", Color: "#0298c3", Aliases: []string{"cperl"}, Extensions: []string{".pl", ".pm", ".t"}},
+	"php":              {Name: "PHP", Color: "#4F5D95", Aliases: []string{"inc"}, Extensions: []string{".php", ".phtml"}},
+	"powershell":       {Name: "PowerShell", Color: "#012456", Aliases: []string{"posh", "pwsh"}, Extensions: []string{".ps1", ".psd1", ".psm1"}},
+	"python":           {Name: "Python", Color: "#3572A5", Aliases: []string{"python3", "rusthon"}, Extensions: []string{".py", ".pyi", ".pyw"}},
+	"r":                {Name: "R", Color: "#198CE7", Aliases: []string{"Rscript", "splus"}, Extensions: []string{".r", ".rd", ".rsx"}},
+	"ruby":             {Name: "Ruby", Color: "#701516", Aliases: []string{"jruby", "macruby", "rake", "rb", "rbx"}, Extensions: []string{".rb", ".gemspec", ".rake"}},
+	"rust":             {Name: "Rust", Color: "#dea584", Aliases: []string{"rs"}, Extensions: []string{".rs"}},
+	"scala":            {Name: "Scala", Color: "#c22d40", Extensions: []string{".scala", ".sc"}},
+	"scss":             {Name: "SCSS", Color: "#c6538c", Extensions: []string{".scss"}},
+	"shell":            {Name: "Shell", Color: "#89e051", Aliases: []string{"sh", "shell-script", "bash", "zsh"}, Extensions: []string{".sh", ".bash", ".zsh"}},
+	"solidity":         {Name: "Solidity", Color: "#AA6746", Extensions: []string{".sol"}},
+	"svelte":           {Name: "Svelte", Color: "#ff3e00", Extensions: []string{".svelte"}},
+	"swift":            {Name: "Swift", Color: "#F05138", Extensions: []string{".swift"}},
+	"templ":            {Name: "templ", Color: "#66D0DD", Extensions: []string{".templ"}},
+	"tex":              {Name: "TeX", Color: "#3D6117", Aliases: []string{"latex"}, Extensions: []string{".tex", ".cls", ".sty"}},
+	"text":             {Name: "Text", Color: "", Aliases: []string{"fundamental", "plain text"}, Extensions: []string{".txt"}},
+	"typescript":       {Name: "TypeScript", Color: "#3178c6", Aliases: []string{"ts"}, Extensions: []string{".ts", ".cts", ".mts"}},
+	"vim script":       {Name: "Vim Script", Color: "#199f4b", Aliases: []string{"vim", "viml", "nvim", "vimscript"}, Extensions: []string{".vim", ".vimrc"}},
+	"vue":              {Name: "Vue", Color: "#41b883", Extensions: []string{".vue"}},
+	"yaml":             {Name: "YAML", Color: "#cb171e", Aliases: []string{"yml"}, Extensions: []string{".yml", ".yaml"}},
+	"zig":              {Name: "Zig", Color: "#ec915c", Extensions: []string{".zig"}},
+}
+
+var aliases = map[string]string{
+	"asm":                              "assembly",
+	"bash":                             "shell",
+	"bsdmake":                          "makefile",
+	"cake":                             "c#",
+	"cakescript":                       "c#",
+	"containerfile":                    "dockerfile",
+	"cperl":                            "perl",
+	"cpp":                              "c++",
+	"csharp":                           "c#",
+	"elisp":                            "emacs lisp",
+	"emacs":                            "emacs lisp",
+	"fsharp":                           "f#",
+	"fundamental":                      "text",
+	"geojson":                          "json",
+	"golang":                           "go",
+	"hashicorp configuration language": "hcl",
+	"inc":                              "php",
+	"ipython notebook":                 "jupyter notebook",
+	"jruby":                            "ruby",
+	"js":                               "javascript",
+	"jsonl":                            "json",
+	"latex":                            "tex",
+	"macruby":                          "ruby",
+	"make":                             "makefile",
+	"md":                               "markdown",
+	"mf":                               "makefile",
+	"nasm":                             "assembly",
+	"nixos":                            "nix",
+	"node":                             "javascript",
+	"nvim":                             "vim script",
+	"obj-c":                            "objective-c",
+	"objc":                             "objective-c",
+	"objectivec":                       "objective-c",
+	"pandoc":                           "markdown",
+	"plain text":                       "text",
+	"posh":                             "powershell",
+	"pwsh":                             "powershell",
+	"python3":                          "python",
+	"rake":                             "ruby",
+	"rb":                               "ruby",
+	"rbx":                              "ruby",
+	"rs":                               "rust",
+	"rscript":                          "r",
+	"rusthon":                          "python",
+	"sh":                               "shell",
+	"shell-script":                     "shell",
+	"splus":                            "r",
+	"terraform":                        "hcl",
+	"topojson":                         "json",
+	"ts":                               "typescript",
+	"vim":                              "vim script",
+	"viml":                             "vim script",
+	"vimscript":                        "vim script",
+	"xhtml":                            "html",
+	"yml":                              "yaml",
+	"zsh":                              "shell",
+}
+
+var extensions = map[string][]string{
+	".a51":        []string{"assembly"},
+	".asm":        []string{"assembly"},
+	".bash":       []string{"shell"},
+	".c":          []string{"c"},
+	".c++":        []string{"c++"},
+	".cake":       []string{"c#"},
+	".cats":       []string{"c"},
+	".cc":         []string{"c++"},
+	".cjs":        []string{"javascript"},
+	".clj":        []string{"clojure"},
+	".cljc":       []string{"clojure"},
+	".cljs":       []string{"clojure"},
+	".cls":        []string{"tex"},
+	".cp":         []string{"c++"},
+	".cpp":        []string{"c++"},
+	".cr":         []string{"crystal"},
+	".cs":         []string{"c#"},
+	".css":        []string{"css"},
+	".csx":        []string{"c#"},
+	".cts":        []string{"typescript"},
+	".cxx":        []string{"c++"},
+	".dart":       []string{"dart"},
+	".dockerfile": []string{"dockerfile"},
+	".edn":        []string{"clojure"},
+	".el":         []string{"emacs lisp"},
+	".elm":        []string{"elm"},
+	".emacs":      []string{"emacs lisp"},
+	".erl":        []string{"erlang"},
+	".ex":         []string{"elixir"},
+	".exs":        []string{"elixir"},
+	".fs":         []string{"f#"},
+	".fsi":        []string{"f#"},
+	".fsx":        []string{"f#"},
+	".gemspec":    []string{"ruby"},
+	".geojson":    []string{"json"},
+	".go":         []string{"go"},
+	".gradle":     []string{"groovy"},
+	".groovy":     []string{"groovy"},
+	".h":          []string{"c", "c++", "objective-c"},
+	".h++":        []string{"c++"},
+	".hcl":        []string{"hcl"},
+	".hh":         []string{"c++"},
+	".hpp":        []string{"c++"},
+	".hrl":        []string{"erlang"},
+	".hs":         []string{"haskell"},
+	".hs-boot":    []string{"haskell"},
+	".hsc":        []string{"haskell"},
+	".htm":        []string{"html"},
+	".html":       []string{"html"},
+	".hxx":        []string{"c++"},
+	".i":          []string{"assembly"},
+	".idc":        []string{"c"},
+	".inc":        []string{"assembly"},
+	".ipynb":      []string{"jupyter notebook"},
+	".jav":        []string{"java"},
+	".java":       []string{"java"},
+	".jl":         []string{"julia"},
+	".js":         []string{"javascript"},
+	".json":       []string{"json"},
+	".jsonl":      []string{"json"},
+	".jsx":        []string{"javascript"},
+	".kt":         []string{"kotlin"},
+	".ktm":        []string{"kotlin"},
+	".kts":        []string{"kotlin"},
+	".linq":       []string{"c#"},
+	".lua":        []string{"lua"},
+	".m":          []string{"objective-c"},
+	".mak":        []string{"makefile"},
+	".make":       []string{"makefile"},
+	".markdown":   []string{"markdown"},
+	".md":         []string{"markdown"},
+	".mdown":      []string{"markdown"},
+	".mjs":        []string{"javascript"},
+	".mk":         []string{"makefile"},
+	".ml":         []string{"ocaml"},
+	".mli":        []string{"ocaml"},
+	".mts":        []string{"typescript"},
+	".nas":        []string{"assembly"},
+	".nasm":       []string{"assembly"},
+	".nim":        []string{"nim"},
+	".nimble":     []string{"nim"},
+	".nix":        []string{"nix"},
+	".php":        []string{"php"},
+	".phtml":      []string{"php"},
+	".pl":         []string{"perl"},
+	".pm":         []string{"perl"},
+	".ps1":        []string{"powershell"},
+	".psd1":       []string{"powershell"},
+	".psm1":       []string{"powershell"},
+	".py":         []string{"python"},
+	".pyi":        []string{"python"},
+	".pyw":        []string{"python"},
+	".r":          []string{"r"},
+	".rake":       []string{"ruby"},
+	".rb":         []string{"ruby"},
+	".rd":         []string{"r"},
+	".rockspec":   []string{"lua"},
+	".rs":         []string{"rust"},
+	".rsx":        []string{"r"},
+	".s":          []string{"assembly"},
+	".sc":         []string{"scala"},
+	".scala":      []string{"scala"},
+	".scss":       []string{"scss"},
+	".sh":         []string{"shell"},
+	".sol":        []string{"solidity"},
+	".sty":        []string{"tex"},
+	".svelte":     []string{"svelte"},
+	".swift":      []string{"swift"},
+	".t":          []string{"perl"},
+	".templ":      []string{"templ"},
+	".tex":        []string{"tex"},
+	".tf":         []string{"hcl"},
+	".tfvars":     []string{"hcl"},
+	".ts":         []string{"typescript"},
+	".txt":        []string{"text"},
+	".vim":        []string{"vim script"},
+	".vimrc":      []string{"vim script"},
+	".vue":        []string{"vue"},
+	".xht":        []string{"html"},
+	".xhtml":      []string{"html"},
+	".yaml":       []string{"yaml"},
+	".yml":        []string{"yaml"},
+	".zig":        []string{"zig"},
+	".zsh":        []string{"shell"},
+}
@@ -0,0 +1,116 @@
+// Command gen generates the lookup tables of the linguist package from the
+// languages.yml file of linguist.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+type language struct {
+	Name       string   `yaml:"-"`
+	Color      string   `yaml:"color"`
+	Aliases    []string `yaml:"aliases"`
+	Extensions []string `yaml:"extensions"`
+}
+
+// Key is the case insensitive name of the language.
+func (l language) Key() string { return strings.ToLower(l.Name) }
+
+var source = template.Must(template.New("source").Parse(`// Code generated by go run ./gen; DO NOT EDIT.
+
+package linguist
+
+var languages = map[string]Language{
+{{- range .Languages }}
+	{{ printf "%q" .Key }}: {Name: {{ printf "%q" .Name }}, Color: {{ printf "%q" .Color }},
+		{{- with .Aliases }} Aliases: {{ printf "%#v" . }},{{ end }}
+		{{- with .Extensions }} Extensions: {{ printf "%#v" . }}{{ end }}},
+{{- end }}
+}
+
+var aliases = map[string]string{
+{{- range $alias, $key := .Aliases }}
+	{{ printf "%q" $alias }}: {{ printf "%q" $key }},
+{{- end }}
+}
+
+var extensions = map[string][]string{
+{{- range $ext, $keys := .Extensions }}
+	{{ printf "%q" $ext }}: {{ printf "%#v" $keys }},
+{{- end }}
+}
+`))
+
+// generate writes the Go source of the tables described by the YAML in.
+func generate(in io.Reader, out io.Writer) error {
+	var definitions map[string]language
+	if err := yaml.NewDecoder(in).Decode(&definitions); err != nil {
+		return fmt.Errorf("decoding languages: %w", err)
+	}
+	var data struct {
+		Languages  []language
+		Aliases    map[string]string
+		Extensions map[string][]string
+	}
+	data.Aliases, data.Extensions = map[string]string{}, map[string][]string{}
+	for name, language := range definitions {
+		language.Name = name
+		data.Languages = append(data.Languages, language)
+	}
+	slices.SortFunc(data.Languages, func(a, b language) int { return strings.Compare(a.Key(), b.Key()) })
+	for _, language := range data.Languages {
+		for _, alias := range language.Aliases {
+			// an alias never shadows the name of another language
+			if alias = strings.ToLower(alias); alias != language.Key() && definitions[alias].Name == "" {
+				data.Aliases[alias] = language.Key()
+			}
+		}
+		for _, ext := range language.Extensions {
+			ext = strings.ToLower(ext)
+			data.Extensions[ext] = append(data.Extensions[ext], language.Key())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := source.Execute(&buf, data); err != nil {
+		return err
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting source: %w", err)
+	}
+	_, err = out.Write(formatted)
+	return err
+}
+
+func main() {
+	in := flag.String("in", "languages.yml", "linguist definitions to read")
+	out := flag.String("out", "colors_gen.go", "Go file to write")
+	flag.Parse()
+
+	input, err := os.Open(*in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer input.Close()
+	var buf bytes.Buffer
+	if err := generate(input, &buf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestGenerated fails when the tables were not generated again after
+// languages.yml changed.
+func TestGenerated(t *testing.T) {
+	in, err := os.Open("../languages.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	var generated bytes.Buffer
+	if err := generate(in, &generated); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile("../colors_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated.Bytes(), committed) {
+		t.Error("colors_gen.go is out of date, run go generate ./internal/linguist")
+	}
+}
@@ -0,0 +1,54 @@
+// Package linguist tells the colors and file extensions of the languages
+// GitHub detects, from a snapshot of the linguist definitions. The snapshot
+// only covers the most common languages, see languages.yml, the others are
+// unknown to Lookup.
+package linguist
+
+//go:generate go run ./gen -in languages.yml -out colors_gen.go
+
+import "strings"
+
+// DefaultColor is given to the languages linguist has no color for, and to
+// the ones missing from the snapshot.
+const DefaultColor = "#cccccc"
+
+// Language is a language known to linguist, Color is empty when linguist
+// does not define one.
+type Language struct {
+	Name       string
+	Color      string
+	Aliases    []string
+	Extensions []string
+}
+
+// Lookup returns the language named or aliased name, case insensitively.
+func Lookup(name string) (Language, bool) {
+	key := strings.ToLower(name)
+	if language, ok := languages[key]; ok {
+		return language, true
+	}
+	if key, ok := aliases[key]; ok {
+		return languages[key], true
+	}
+	return Language{}, false
+}
+
+// Color returns the color of the language named or aliased name, DefaultColor
+// is returned for unknown languages.
+func Color(name string) string {
+	if language, ok := Lookup(name); ok && language.Color != "" {
+		return language.Color
+	}
+	return DefaultColor
+}
+
+// ByExtension returns the languages of the snapshot using the file extension
+// ext (".go"), most extensions belong to a single language. Extensions of the
+// languages missing from the snapshot give none.
+func ByExtension(ext string) []Language {
+	var found []Language
+	for _, key := range extensions[strings.ToLower(ext)] {
+		found = append(found, languages[key])
+	}
+	return found
+}
@@ -0,0 +1,48 @@
+package linguist
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestColor(t *testing.T) {
+	for _, test := range []struct {
+		name, expected string
+	}{
+		{"Go", "#00ADD8"},
+		{"go", "#00ADD8"},
+		{"JAVASCRIPT", "#f1e05a"},
+		{"golang", "#00ADD8"},
+		{"Plain Text", DefaultColor},
+		{"Text", DefaultColor},
+		{"Brainfuck", DefaultColor},
+		{"", DefaultColor},
+	} {
+		if color := Color(test.name); color != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.name, test.expected, color)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	language, ok := Lookup("cpp")
+	if !ok || language.Name != "C++" {
+		t.Fatalf("expected the alias to resolve C++, got %+v", language)
+	}
+	if _, ok := Lookup("Brainfuck"); ok {
+		t.Error("expected unknown languages not to be found")
+	}
+}
+
+func TestByExtension(t *testing.T) {
+	var names []string
+	for _, language := range ByExtension(".H") {
+		names = append(names, language.Name)
+	}
+	if !slices.Equal(names, []string{"C", "C++", "Objective-C"}) {
+		t.Errorf("expected the languages using .h, got %v", names)
+	}
+	if languages := ByExtension(".unknown"); len(languages) != 0 {
+		t.Errorf("expected no language, got %v", languages)
+	}
+}
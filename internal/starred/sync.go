@@ -0,0 +1,257 @@
+// Package starred keeps a local copy of the repositories starred by users, so
+// pages are served without waiting on (or failing with) the GitHub API.
+package starred
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/auth"
+	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/internal/log"
+	"github.com/platipy-io/d2s/types"
+)
+
+const (
+	DefaultInterval     = 15 * time.Minute
+	DefaultFullInterval = 24 * time.Hour
+)
+
+var ErrSync = xerrors.Message("failed syncing starred repositories")
+
+// Store reads the tokens of users and holds their repositories, data.DB
+// implements it.
+type Store interface {
+	auth.TokenStore
+	SyncState(ctx context.Context, userID int64) (*types.SyncState, error)
+	SaveSyncState(ctx context.Context, state *types.SyncState) error
+	SaveStarred(ctx context.Context, repos []*types.Repository, state *types.SyncState) error
+	UsersToSync(ctx context.Context, provider string, before time.Time) ([]int64, error)
+}
+
+type syncerConfig struct {
+	baseURL      string
+	interval     time.Duration
+	fullInterval time.Duration
+	perPage      int
+	maxItems     int
+}
+
+// SyncerOption applies a configuration option value to a Syncer.
+type SyncerOption interface {
+	apply(syncerConfig) syncerConfig
+}
+
+type SyncerOptionFunc func(syncerConfig) syncerConfig
+
+func (fn SyncerOptionFunc) apply(c syncerConfig) syncerConfig {
+	return fn(c)
+}
+
+// WithBaseURL calls another GitHub API endpoint, an Enterprise instance or a
+// test server.
+func WithBaseURL(url string) SyncerOption {
+	return SyncerOptionFunc(func(sc syncerConfig) syncerConfig {
+		sc.baseURL = url
+		return sc
+	})
+}
+
+// WithInterval sets how often users are checked for changes.
+func WithInterval(interval time.Duration) SyncerOption {
+	return SyncerOptionFunc(func(sc syncerConfig) syncerConfig {
+		sc.interval = interval
+		return sc
+	})
+}
+
+// WithFullInterval sets how often the listing is fetched even if GitHub
+// reports no change. Only the first page is conditional, a star removed
+// further down would go unnoticed otherwise.
+func WithFullInterval(interval time.Duration) SyncerOption {
+	return SyncerOptionFunc(func(sc syncerConfig) syncerConfig {
+		sc.fullInterval = interval
+		return sc
+	})
+}
+
+// WithPerPage sets the page size of the requests listing the repositories.
+func WithPerPage(perPage int) SyncerOption {
+	return SyncerOptionFunc(func(sc syncerConfig) syncerConfig {
+		sc.perPage = perPage
+		return sc
+	})
+}
+
+// WithMaxItems bounds how many repositories are copied per user.
+func WithMaxItems(maxItems int) SyncerOption {
+	return SyncerOptionFunc(func(sc syncerConfig) syncerConfig {
+		sc.maxItems = maxItems
+		return sc
+	})
+}
+
+// Syncer copies the starred repositories of the users logged in with the
+// github provider.
+type Syncer struct {
+	syncerConfig
+	store    Store
+	provider auth.Provider
+
+	mu    sync.Mutex
+	locks map[int64]*userLock
+}
+
+// userLock counts the syncs holding or waiting for it, so it can be forgotten
+// once the last one is done.
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+func NewSyncer(store Store, provider auth.Provider, opts ...SyncerOption) *Syncer {
+	sc := syncerConfig{interval: DefaultInterval, fullInterval: DefaultFullInterval,
+		perPage: github.DefaultPerPage, maxItems: github.DefaultMaxStarred}
+	for _, opt := range opts {
+		sc = opt.apply(sc)
+	}
+	return &Syncer{syncerConfig: sc, store: store, provider: provider,
+		locks: map[int64]*userLock{}}
+}
+
+// lock serializes the syncs of a user, between the worker and requests.
+func (s *Syncer) lock(userID int64) {
+	s.mu.Lock()
+	lock, ok := s.locks[userID]
+	if !ok {
+		lock = &userLock{}
+		s.locks[userID] = lock
+	}
+	lock.refs++
+	s.mu.Unlock()
+	lock.Lock()
+}
+
+// unlock releases the lock of the user, removing it when nobody waits for it.
+func (s *Syncer) unlock(userID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock := s.locks[userID]
+	lock.Unlock()
+	if lock.refs--; lock.refs == 0 {
+		delete(s.locks, userID)
+	}
+}
+
+// Sync refreshes the repositories of the user. The listing is requested with
+// the ETag of the previous one and only stored again when it changed. Failures
+// are recorded in the returned state, the previous copy is kept.
+func (s *Syncer) Sync(ctx context.Context, userID int64) (*types.SyncState, error) {
+	s.lock(userID)
+	defer s.unlock(userID)
+
+	state, err := s.store.SyncState(ctx, userID)
+	if errors.Is(err, data.ErrNotFound) {
+		state = &types.SyncState{UserID: userID}
+	} else if err != nil {
+		return nil, xerrors.WithWrapper(ErrSync, err)
+	}
+	now := time.Now()
+	etag := state.ETag
+	if now.Sub(state.Synced) > s.fullInterval {
+		etag = ""
+	}
+
+	repos, etag, err := s.fetch(ctx, userID, etag)
+	state.Checked = now
+	switch {
+	case errors.Is(err, github.ErrNotModified):
+		state.Error = ""
+		return state, s.store.SaveSyncState(ctx, state)
+	case err != nil:
+		state.Error = err.Error()
+		return state, xerrors.Append(xerrors.WithWrapper(ErrSync, err),
+			s.store.SaveSyncState(ctx, state))
+	}
+	state.ETag, state.Synced, state.Error = etag, now, ""
+	return state, s.store.SaveStarred(ctx, repos, state)
+}
+
+func (s *Syncer) fetch(ctx context.Context, userID int64, etag string) (repos []*types.Repository, _ string, err error) {
+	// the worker has no handler to recover from a malformed response
+	defer xerrors.Recover(func(perr error) { err = perr })
+
+	source, err := auth.NewTokenSource(ctx, s.store, s.provider, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	client := github.NewClient(source)
+	if s.baseURL != "" {
+		if err := client.SetBaseURL(s.baseURL); err != nil {
+			return nil, "", err
+		}
+	}
+	return client.Starred(ctx, etag,
+		github.WithPerPage(s.perPage), github.WithMaxItems(s.maxItems))
+}
+
+// SyncDue syncs the users which were not checked for an interval and returns
+// how many were synced successfully.
+func (s *Syncer) SyncDue(ctx context.Context) (count int, err error) {
+	users, err := s.store.UsersToSync(ctx, s.provider.Name(), time.Now().Add(-s.interval))
+	if err != nil {
+		return 0, xerrors.WithWrapper(ErrSync, err)
+	}
+	for _, userID := range users {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		if _, serr := s.Sync(ctx, userID); serr != nil {
+			err = xerrors.Append(err, serr)
+			continue
+		}
+		count++
+	}
+	return count, err
+}
+
+// Run syncs the users due at a fraction of the interval until the context is
+// done, so a user is checked at most one interval late.
+func (s *Syncer) Run(ctx context.Context) {
+	logger := log.Ctx(ctx)
+	ticker := time.NewTicker(s.interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if count, err := s.SyncDue(ctx); err != nil {
+				logger.Error().Ctx(ctx).Err(err).Int("count", count).Msg("failed syncing starred repositories")
+			} else if count != 0 {
+				logger.Debug().Ctx(ctx).Int("count", count).Msg("synced starred repositories")
+			}
+		}
+	}
+}
+
+type syncerKey struct{}
+
+// Middleware makes the syncer available to handlers through FromContext.
+func (s *Syncer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), syncerKey{}, s)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func FromContext(ctx context.Context) *Syncer {
+	s, _ := ctx.Value(syncerKey{}).(*Syncer)
+	return s
+}
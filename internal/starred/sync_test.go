@@ -0,0 +1,262 @@
+package starred
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/data/datatest"
+	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/types"
+)
+
+// api stands in for the GitHub API, serving the starred repositories in pages
+// linked by Link headers, with an ETag and answering conditional requests.
+type api struct {
+	*httptest.Server
+	etag    string
+	starred []map[string]any
+	calls   atomic.Int32
+	status  int
+}
+
+func newAPI(t *testing.T) *api {
+	a := &api{etag: `"v1"`}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.calls.Add(1)
+		if r.URL.Path != "/user/starred" || r.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if a.status != 0 {
+			http.Error(w, `{"message": "unavailable"}`, a.status)
+			return
+		}
+		if r.Header.Get("If-None-Match") == a.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		start := min((page-1)*perPage, len(a.starred))
+		end := min(start+perPage, len(a.starred))
+		if end < len(a.starred) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/user/starred?page=%d&per_page=%d>; rel="next"`,
+				a.URL, page+1, perPage))
+		}
+		w.Header().Set("ETag", a.etag)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.starred[start:end])
+	}))
+	t.Cleanup(a.Close)
+	return a
+}
+
+func starredRepo(id int64, owner, name string) map[string]any {
+	return map[string]any{
+		"starred_at": "2024-01-02T15:04:05Z",
+		"repo": map[string]any{
+			"id": id, "name": name, "owner": map[string]any{"login": owner},
+			"description": "description of " + name, "language": "Go",
+			"updated_at": "2024-01-01T00:00:00Z", "stargazers_count": id * 10,
+			"html_url": "https://github.com/" + owner + "/" + name, "topics": []string{"topic"},
+		},
+	}
+}
+
+func newSyncer(t *testing.T, db *data.DB, a *api, opts ...SyncerOption) *Syncer {
+	t.Helper()
+	provider, err := github.NewProvider("http://localhost/callback", "id", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSyncer(db, provider, append([]SyncerOption{WithBaseURL(a.URL)}, opts...)...)
+}
+
+func names(repos []*types.Repository) (out []string) {
+	for _, repo := range repos {
+		out = append(out, repo.Owner+"/"+repo.Name)
+	}
+	return out
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	db, userID := datatest.NewStore(t)
+	a := newAPI(t)
+	a.starred = []map[string]any{starredRepo(2, "b", "two"), starredRepo(1, "a", "one")}
+	syncer := newSyncer(t, db, a)
+
+	state, err := syncer.Sync(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ETag != `"v1"` || state.Synced.IsZero() || state.Error != "" {
+		t.Errorf("unexpected state %+v", state)
+	}
+	repos, err := db.StarredRepositories(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(repos); len(got) != 2 || got[0] != "b/two" || got[1] != "a/one" {
+		t.Fatalf("unexpected repositories %v", got)
+	}
+	expected := &types.Repository{ID: 2, Owner: "b", Name: "two", Description: "description of two",
+		Language: "Go", LastUpdated: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		HTMLURL: "https://github.com/b/two", Stars: 20, Topics: []string{"topic"}}
+	if !reflect.DeepEqual(repos[0], expected) {
+		t.Errorf("expected %+v\ngot      %+v", expected, repos[0])
+	}
+
+	// unchanged listing, the copy is kept and only the check time moves
+	synced := state.Synced
+	if state, err = syncer.Sync(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Synced.Equal(synced) || !state.Checked.After(synced) {
+		t.Errorf("unexpected state after not modified %+v", state)
+	}
+
+	// new star, new ETag
+	a.etag = `"v2"`
+	a.starred = append([]map[string]any{starredRepo(3, "c", "three")}, a.starred...)
+	if _, err = syncer.Sync(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if repos, _ = db.StarredRepositories(ctx, userID); len(repos) != 3 || repos[0].ID != 3 {
+		t.Errorf("unexpected repositories after change %v", names(repos))
+	}
+}
+
+func TestSyncReleasesLocks(t *testing.T) {
+	ctx := context.Background()
+	db, userID := datatest.NewStore(t)
+	a := newAPI(t)
+	a.starred = []map[string]any{starredRepo(1, "a", "one")}
+	syncer := newSyncer(t, db, a)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := syncer.Sync(ctx, userID); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(syncer.locks) != 0 {
+		t.Errorf("expected no lock left, got %d", len(syncer.locks))
+	}
+}
+
+func TestSyncPagination(t *testing.T) {
+	ctx := context.Background()
+	db, userID := datatest.NewStore(t)
+	a := newAPI(t)
+	for i := range 7 {
+		a.starred = append(a.starred, starredRepo(int64(i+1), "owner", strconv.Itoa(i+1)))
+	}
+
+	for _, test := range []struct {
+		name     string
+		opts     []SyncerOption
+		expected int
+		calls    int32
+	}{
+		{"all pages", []SyncerOption{WithPerPage(3)}, 7, 3},
+		{"single page", nil, 7, 1},
+		{"capped", []SyncerOption{WithPerPage(3), WithMaxItems(4)}, 4, 2},
+		{"capped in page", []SyncerOption{WithPerPage(3), WithMaxItems(3)}, 3, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a.calls.Store(0)
+			// a different ETag each time so the listing is fetched in full
+			a.etag = strconv.Quote(test.name)
+			if _, err := newSyncer(t, db, a, test.opts...).Sync(ctx, userID); err != nil {
+				t.Fatal(err)
+			}
+			repos, err := db.StarredRepositories(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(repos) != test.expected || repos[0].ID != 1 {
+				t.Errorf("expected %d repositories, got %v", test.expected, names(repos))
+			}
+			if calls := a.calls.Load(); calls != test.calls {
+				t.Errorf("expected %d API calls, got %d", test.calls, calls)
+			}
+		})
+	}
+}
+
+func TestSyncCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db, userID := datatest.NewStore(t)
+	a := newAPI(t)
+	a.starred = []map[string]any{starredRepo(1, "a", "one"), starredRepo(2, "b", "two")}
+	cancel()
+
+	if _, err := newSyncer(t, db, a, WithPerPage(1)).Sync(ctx, userID); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if calls := a.calls.Load(); calls != 0 {
+		t.Errorf("expected no API call, got %d", calls)
+	}
+}
+
+func TestSyncFailureKeepsCopy(t *testing.T) {
+	ctx := context.Background()
+	db, userID := datatest.NewStore(t)
+	a := newAPI(t)
+	a.starred = []map[string]any{starredRepo(1, "a", "one")}
+	syncer := newSyncer(t, db, a)
+	if _, err := syncer.Sync(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	a.status, a.etag = http.StatusBadGateway, `"v2"`
+	state, err := syncer.Sync(ctx, userID)
+	if !errors.Is(err, ErrSync) || !errors.Is(err, github.ErrStarred) {
+		t.Fatalf("expected sync error, got %v", err)
+	}
+	if state.Error == "" || state.ETag != `"v1"` {
+		t.Errorf("unexpected state %+v", state)
+	}
+	stored, err := db.SyncState(ctx, userID)
+	if err != nil || stored.Error == "" {
+		t.Errorf("failure not recorded %+v %v", stored, err)
+	}
+	if repos, _ := db.StarredRepositories(ctx, userID); len(repos) != 1 {
+		t.Errorf("copy lost on failure %v", names(repos))
+	}
+}
+
+func TestSyncDue(t *testing.T) {
+	ctx := context.Background()
+	db, _ := datatest.NewStore(t)
+	a := newAPI(t)
+	syncer := newSyncer(t, db, a)
+
+	if count, err := syncer.SyncDue(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 user synced, got %d %v", count, err)
+	}
+	// checked within the interval
+	if count, err := syncer.SyncDue(ctx); err != nil || count != 0 {
+		t.Fatalf("expected no user due, got %d %v", count, err)
+	}
+	if calls := a.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 API call, got %d", calls)
+	}
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"context"
+
+	gogithub "github.com/google/go-github/v68/github"
+
+	"github.com/platipy-io/d2s/internal/github"
+)
+
+// star adds or removes the repository from the copy of the user who starred
+// it.
+func star(ctx context.Context, store Store, event any) error {
+	e := event.(*gogithub.StarEvent)
+	user, err := user(ctx, store, e.GetSender())
+	if err != nil || user == nil || e.GetRepo().GetID() == 0 {
+		return err
+	}
+	switch e.GetAction() {
+	case "created":
+		return store.AddStarred(ctx, user.ID, github.NewRepository(e.GetRepo()))
+	case "deleted":
+		return store.RemoveStarred(ctx, user.ID, e.GetRepo().GetID())
+	}
+	return nil
+}
+
+// repository refreshes the details of the repository in every copy, or drops
+// it once deleted.
+func repository(ctx context.Context, store Store, event any) error {
+	e := event.(*gogithub.RepositoryEvent)
+	if e.GetRepo().GetID() == 0 {
+		return nil
+	}
+	switch e.GetAction() {
+	case "deleted":
+		return store.DeleteRepository(ctx, e.GetRepo().GetID())
+	case "edited", "renamed", "transferred", "archived", "unarchived", "publicized", "privatized":
+		return store.UpdateRepository(ctx, github.NewRepository(e.GetRepo()))
+	}
+	return nil
+}
+
+// installation copies the whole listing again on the next sync once the app
+// is (re)installed, the events missed meanwhile are caught up on.
+func installation(ctx context.Context, store Store, event any) error {
+	e := event.(*gogithub.InstallationEvent)
+	switch e.GetAction() {
+	case "created", "unsuspend", "new_permissions_accepted":
+		user, err := user(ctx, store, e.GetSender())
+		if err != nil || user == nil {
+			return err
+		}
+		return store.ExpireSyncState(ctx, user.ID)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package webhook receives the GitHub webhooks, so changes to the starred
+// repositories show up without waiting for the next sync.
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gogithub "github.com/google/go-github/v68/github"
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/internal/log"
+	"github.com/platipy-io/d2s/types"
+)
+
+const (
+	// DefaultReplayWindow is how long deliveries are remembered. GitHub does
+	// not sign when a payload was sent, a delivery older than this could be
+	// replayed.
+	DefaultReplayWindow = 7 * 24 * time.Hour
+	// maxPayload is the size GitHub caps payloads to.
+	maxPayload = 25 << 20
+)
+
+var (
+	ErrSecret    = xerrors.Message("webhook secret is not configured")
+	ErrSignature = xerrors.Message("invalid webhook signature")
+	ErrDelivery  = xerrors.Message("missing webhook delivery")
+	ErrReplay    = xerrors.Message("webhook delivery already received")
+	ErrEvent     = xerrors.Message("failed handling webhook event")
+)
+
+// Store records the deliveries and holds the repositories updated by the
+// events, data.DB implements it.
+type Store interface {
+	RecordDelivery(ctx context.Context, id string, received time.Time) error
+	ForgetDelivery(ctx context.Context, id string) error
+	PurgeDeliveries(ctx context.Context, before time.Time) error
+	GetUserBySubject(ctx context.Context, provider, subject string) (*types.User, error)
+	AddStarred(ctx context.Context, userID int64, repo *types.Repository) error
+	RemoveStarred(ctx context.Context, userID, repoID int64) error
+	UpdateRepository(ctx context.Context, repo *types.Repository) error
+	DeleteRepository(ctx context.Context, repoID int64) error
+	ExpireSyncState(ctx context.Context, userID int64) error
+}
+
+type receiverConfig struct {
+	replayWindow time.Duration
+}
+
+// ReceiverOption applies a configuration option value to a Receiver.
+type ReceiverOption interface {
+	apply(receiverConfig) receiverConfig
+}
+
+type ReceiverOptionFunc func(receiverConfig) receiverConfig
+
+func (fn ReceiverOptionFunc) apply(c receiverConfig) receiverConfig {
+	return fn(c)
+}
+
+// WithReplayWindow sets how long deliveries are remembered to reject their
+// replays.
+func WithReplayWindow(window time.Duration) ReceiverOption {
+	return ReceiverOptionFunc(func(rc receiverConfig) receiverConfig {
+		rc.replayWindow = window
+		return rc
+	})
+}
+
+// Handler applies an event of a delivery to the local data.
+type Handler func(ctx context.Context, store Store, event any) error
+
+// handlers dispatch the supported events, the others are acknowledged and
+// ignored.
+var handlers = map[string]Handler{
+	"ping":         func(context.Context, Store, any) error { return nil },
+	"star":         star,
+	"repository":   repository,
+	"installation": installation,
+}
+
+// Receiver verifies the deliveries of GitHub against the secret of the
+// webhook and dispatches their events.
+type Receiver struct {
+	store        Store
+	secret       []byte
+	replayWindow time.Duration
+}
+
+func NewReceiver(store Store, secret string, opts ...ReceiverOption) (*Receiver, error) {
+	if secret == "" {
+		return nil, ErrSecret
+	}
+	config := receiverConfig{replayWindow: DefaultReplayWindow}
+	for _, opt := range opts {
+		config = opt.apply(config)
+	}
+	return &Receiver{store: store, secret: []byte(secret), replayWindow: config.replayWindow}, nil
+}
+
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := log.Ctx(ctx)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	// the payload is only trusted once its signature matches
+	if err := validateSignature(r.Header.Get(gogithub.SHA256SignatureHeader), payload, rc.secret); err != nil {
+		logger.Warn().Ctx(ctx).Err(xerrors.WithWrapper(ErrSignature, err)).Msg("rejecting webhook")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	delivery, event := gogithub.DeliveryID(r), gogithub.WebHookType(r)
+	if delivery == "" {
+		logger.Warn().Ctx(ctx).Err(ErrDelivery).Msg("rejecting webhook")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	status, err := rc.receive(ctx, delivery, event, payload)
+	if err != nil {
+		logger.Error().Ctx(ctx).Stack().Err(err).Str("delivery", delivery).Str("event", event).
+			Msg("failed receiving webhook")
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	logger.Debug().Ctx(ctx).Str("delivery", delivery).Str("event", event).Msg("received webhook")
+	w.WriteHeader(status)
+}
+
+// validateSignature only accepts the SHA-256 signatures, the header could
+// carry a weaker one otherwise.
+func validateSignature(signature string, payload, secret []byte) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return xerrors.New(ErrSignature, "unexpected signature", signature)
+	}
+	return gogithub.ValidateSignature(signature, payload, secret)
+}
+
+// receive handles a verified delivery and returns the status to answer with.
+// A delivery failing to be handled is forgotten, so GitHub can redeliver it.
+func (rc *Receiver) receive(ctx context.Context, delivery, event string, payload []byte) (int, error) {
+	now := time.Now()
+	if err := rc.store.RecordDelivery(ctx, delivery, now); errors.Is(err, data.ErrDuplicate) {
+		return http.StatusConflict, xerrors.New(ErrReplay, delivery)
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := rc.store.PurgeDeliveries(ctx, now.Add(-rc.replayWindow)); err != nil {
+		log.Ctx(ctx).Warn().Ctx(ctx).Err(err).Msg("failed purging webhook deliveries")
+	}
+	handler, ok := handlers[event]
+	if !ok {
+		return http.StatusAccepted, nil
+	}
+	parsed, err := gogithub.ParseWebHook(event, payload)
+	if err != nil {
+		rc.forget(ctx, delivery)
+		return http.StatusBadRequest, xerrors.New(ErrEvent, event, err)
+	}
+	if err := handler(ctx, rc.store, parsed); err != nil {
+		rc.forget(ctx, delivery)
+		return http.StatusInternalServerError, xerrors.New(ErrEvent, event, err)
+	}
+	return http.StatusNoContent, nil
+}
+
+func (rc *Receiver) forget(ctx context.Context, delivery string) {
+	if err := rc.store.ForgetDelivery(ctx, delivery); err != nil {
+		log.Ctx(ctx).Error().Ctx(ctx).Err(err).Msg("failed forgetting webhook delivery")
+	}
+}
+
+// user returns the local user matching the GitHub account, nil is returned
+// for accounts which never logged in.
+func user(ctx context.Context, store Store, account *gogithub.User) (*types.User, error) {
+	user, err := store.GetUserBySubject(ctx, github.ProviderName, strconv.FormatInt(account.GetID(), 10))
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, nil
+	}
+	return user, err
+}
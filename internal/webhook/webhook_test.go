@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/data/datatest"
+	"github.com/platipy-io/d2s/types"
+)
+
+const secret = "It's a Secret to Everybody"
+
+// delivery is a fixture payload replayed as GitHub sends it.
+type delivery struct {
+	event, fixture, id string
+	// signature overrides the one computed with secret
+	signature string
+}
+
+func sign(key string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d delivery) replay(t *testing.T, handler http.Handler) int {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", d.fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := d.signature
+	if signature == "" {
+		signature = sign(secret, payload)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/hooks/github", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", d.event)
+	req.Header.Set("X-GitHub-Delivery", d.id)
+	req.Header.Set("X-Hub-Signature-256", signature)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+var (
+	goRepo  = &types.Repository{ID: 23096959, Owner: "golang", Name: "go", Language: "Go"}
+	muxRepo = &types.Repository{ID: 1062897, Owner: "IxDay", Name: "gorilla-mux", Fork: true}
+)
+
+// newStore returns a database holding the starred copy of the user GitHub
+// knows as datatest.Subject.
+func newStore(t *testing.T) (*data.DB, int64) {
+	t.Helper()
+	db, userID := datatest.NewStore(t)
+	state := &types.SyncState{UserID: userID, ETag: `"listing"`, Checked: time.Now(), Synced: time.Now()}
+	if err := db.SaveStarred(context.Background(), []*types.Repository{goRepo, muxRepo}, state); err != nil {
+		t.Fatal(err)
+	}
+	return db, userID
+}
+
+func newReceiver(t *testing.T, store Store) *Receiver {
+	t.Helper()
+	receiver, err := NewReceiver(store, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receiver
+}
+
+func starredIDs(t *testing.T, db *data.DB, userID int64) (ids []int64) {
+	t.Helper()
+	repos, err := db.StarredRepositories(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		ids = append(ids, repo.ID)
+	}
+	return ids
+}
+
+func TestReceiverRejects(t *testing.T) {
+	db, _ := newStore(t)
+	receiver := newReceiver(t, db)
+	payload, err := os.ReadFile(filepath.Join("testdata", "ping.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		delivery delivery
+		expected int
+	}{
+		{"unsigned", delivery{"ping", "ping.json", "1", "sha256="}, http.StatusForbidden},
+		{"other secret", delivery{"ping", "ping.json", "2", sign("guessed", payload)}, http.StatusForbidden},
+		{"sha1", delivery{"ping", "ping.json", "3", "sha1=" + sign(secret, payload)[7:]}, http.StatusForbidden},
+		// signed for another payload
+		{"tampered", delivery{"ping", "star_created.json", "4", sign(secret, payload)}, http.StatusForbidden},
+		{"no delivery", delivery{"ping", "ping.json", "", ""}, http.StatusBadRequest},
+		{"malformed", delivery{"star", "malformed.json", "5", ""}, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if code := test.delivery.replay(t, receiver); code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, code)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/hooks/github", nil)
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestReceiverReplay(t *testing.T) {
+	db, userID := newStore(t)
+	receiver := newReceiver(t, db)
+	created := delivery{event: "star", fixture: "star_created.json", id: "72d3162e-cc78-11e3-81ab-4c9367dc0958"}
+	if code := created.replay(t, receiver); code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, code)
+	}
+	deleted := delivery{event: "star", fixture: "star_deleted.json", id: "9a1e3b52-cc78-11e3-81ab-4c9367dc0958"}
+	if code := deleted.replay(t, receiver); code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, code)
+	}
+	// replaying the star would add the repository back
+	if code := created.replay(t, receiver); code != http.StatusConflict {
+		t.Errorf("expected %d, got %d", http.StatusConflict, code)
+	}
+	if ids := starredIDs(t, db, userID); len(ids) != 2 {
+		t.Errorf("expected the replay to be ignored, got %v", ids)
+	}
+}
+
+// failingStore fails adding stars until it is told otherwise.
+type failingStore struct {
+	Store
+	fail bool
+}
+
+func (s *failingStore) AddStarred(ctx context.Context, userID int64, repo *types.Repository) error {
+	if s.fail {
+		return errors.New("database is locked")
+	}
+	return s.Store.AddStarred(ctx, userID, repo)
+}
+
+func TestReceiverRedelivery(t *testing.T) {
+	db, userID := newStore(t)
+	store := &failingStore{Store: db, fail: true}
+	receiver := newReceiver(t, store)
+	created := delivery{event: "star", fixture: "star_created.json", id: "redelivered"}
+	if code := created.replay(t, receiver); code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, got %d", http.StatusInternalServerError, code)
+	}
+	// the failed delivery is not held against its redelivery
+	store.fail = false
+	if code := created.replay(t, receiver); code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, code)
+	}
+	if ids := starredIDs(t, db, userID); len(ids) != 3 || ids[0] != 1296269 {
+		t.Errorf("expected the star on top, got %v", ids)
+	}
+}
+
+func TestReceiverEvents(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		deliveries []delivery
+		code       int
+		expected   []*types.Repository
+	}{
+		{"star created", []delivery{{event: "star", fixture: "star_created.json"}}, http.StatusNoContent,
+			[]*types.Repository{{ID: 1296269, Owner: "octocat", Name: "Hello-World",
+				Description: "My first repository on GitHub!", Language: "Go",
+				LastUpdated: date("2024-11-20T08:12:01Z"), HTMLURL: "https://github.com/octocat/Hello-World",
+				Stars: 2712, Forks: 2403, Topics: []string{"example"}}, goRepo, muxRepo}},
+		{"star deleted", []delivery{{event: "star", fixture: "star_created.json"},
+			{event: "star", fixture: "star_deleted.json"}}, http.StatusNoContent,
+			[]*types.Repository{goRepo, muxRepo}},
+		{"star by stranger", []delivery{{event: "star", fixture: "star_stranger.json"}}, http.StatusNoContent,
+			[]*types.Repository{goRepo, muxRepo}},
+		{"repository renamed", []delivery{{event: "repository", fixture: "repository_renamed.json"}}, http.StatusNoContent,
+			[]*types.Repository{goRepo, {ID: 1062897, Owner: "IxDay", Name: "mux",
+				Description: "A powerful HTTP router and URL matcher for building Go web servers",
+				Language:    "Go", LastUpdated: date("2024-11-21T18:40:12Z"),
+				HTMLURL: "https://github.com/IxDay/mux", Stars: 4, Topics: []string{},
+				Archived: true, Fork: true}}},
+		{"repository deleted", []delivery{{event: "repository", fixture: "repository_deleted.json"}}, http.StatusNoContent,
+			[]*types.Repository{goRepo}},
+		{"ping", []delivery{{event: "ping", fixture: "ping.json"}}, http.StatusNoContent,
+			[]*types.Repository{goRepo, muxRepo}},
+		{"unknown event", []delivery{{event: "push", fixture: "ping.json"}}, http.StatusAccepted,
+			[]*types.Repository{goRepo, muxRepo}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db, userID := newStore(t)
+			receiver := newReceiver(t, db)
+			for i, d := range test.deliveries {
+				d.id = test.name + string(rune('a'+i))
+				if code := d.replay(t, receiver); code != test.code {
+					t.Fatalf("delivery %d: expected %d, got %d", i, test.code, code)
+				}
+			}
+			repos, err := db.StarredRepositories(context.Background(), userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(repos) != len(test.expected) {
+				t.Fatalf("expected %d repositories, got %d", len(test.expected), len(repos))
+			}
+			for i, repo := range repos {
+				expected := *test.expected[i]
+				if expected.Topics == nil {
+					expected.Topics = []string{}
+				}
+				if !repo.LastUpdated.Equal(expected.LastUpdated) {
+					t.Errorf("repository %d: expected update %s, got %s", i, expected.LastUpdated, repo.LastUpdated)
+				}
+				repo.LastUpdated, expected.LastUpdated = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(repo, &expected) {
+					t.Errorf("repository %d:\nexpected %+v\ngot      %+v", i, &expected, repo)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiverInstallation(t *testing.T) {
+	db, userID := newStore(t)
+	receiver := newReceiver(t, db)
+	d := delivery{event: "installation", fixture: "installation_created.json", id: "installed"}
+	if code := d.replay(t, receiver); code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, code)
+	}
+	state, err := db.SyncState(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ETag != "" || !state.Checked.IsZero() {
+		t.Errorf("expected the sync state to be expired, got %+v", state)
+	}
+}
+
+func date(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"time"
@@ -11,7 +13,11 @@ import (
 	"github.com/platipy-io/d2s/app"
 	"github.com/platipy-io/d2s/app/lorem"
 	"github.com/platipy-io/d2s/config"
+	"github.com/platipy-io/d2s/data"
+	"github.com/platipy-io/d2s/internal/github"
+	"github.com/platipy-io/d2s/internal/starred"
 	"github.com/platipy-io/d2s/internal/telemetry"
+	"github.com/platipy-io/d2s/internal/webhook"
 	"github.com/platipy-io/d2s/server"
 )
 
@@ -23,12 +29,52 @@ var (
 	Name                  = "d2s"
 )
 
+type (
+	cli struct {
+		config.Configuration
+		Serve   serveCmd   `kong:"cmd,default='1',help='Start the service (default)'"`
+		Migrate migrateCmd `kong:"cmd,help='Manage database migrations'"`
+		DB      dbCmd      `kong:"cmd,name='db',help='Backup and restore the database'"`
+	}
+
+	serveCmd   struct{}
+	migrateCmd struct {
+		Up     migrateUpCmd     `kong:"cmd,help='Apply all pending migrations'"`
+		Down   migrateDownCmd   `kong:"cmd,help='Revert the last applied migrations'"`
+		Status migrateStatusCmd `kong:"cmd,help='List migrations and their state'"`
+		Create migrateCreateCmd `kong:"cmd,help='Create a new pair of migration files'"`
+	}
+	migrateUpCmd   struct{}
+	migrateDownCmd struct {
+		Steps int `kong:"help='Number of migrations to revert',default='1'"`
+	}
+	migrateStatusCmd struct{}
+	migrateCreateCmd struct {
+		Name string `kong:"arg,help='Name of the migration'"`
+		Dir  string `kong:"help='Directory holding the migration files',default='data',type='existingdir'"`
+	}
+
+	dbCmd struct {
+		Backup  dbBackupCmd  `kong:"cmd,help='Take a verified snapshot of the database'"`
+		Restore dbRestoreCmd `kong:"cmd,help='Replace the database with a snapshot (stop the server first)'"`
+		Verify  dbVerifyCmd  `kong:"cmd,help='Run an integrity check on a snapshot'"`
+	}
+	dbBackupCmd  struct{}
+	dbRestoreCmd struct {
+		Snapshot string `kong:"arg,type='existingfile',help='Path to the snapshot to restore'"`
+	}
+	dbVerifyCmd struct {
+		Snapshot string `kong:"arg,type='existingfile',help='Path to the snapshot to verify'"`
+	}
+)
+
 func main() {
-	conf := &config.Configuration{}
+	cmd := &cli{}
+	conf := &cmd.Configuration
 
-	ctx := kong.Parse(conf,
+	ctx := kong.Parse(cmd,
 		kong.Name(Name),
-		kong.Description("Start the service."),
+		kong.Description("Day 2 Stack server."),
 		kong.UsageOnError(),
 		kong.Bind(conf),
 		kong.ConfigureHelp(kong.HelpOptions{
@@ -47,17 +93,110 @@ func main() {
 		}),
 	)
 
-	ctx.FatalIfErrorf(run(conf))
+	ctx.FatalIfErrorf(ctx.Run())
+}
+
+func (serveCmd) Run(c *config.Configuration) error { return run(c) }
+
+func (migrateUpCmd) Run(c *config.Configuration) error {
+	db, err := c.NewClient()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	applied, err := db.MigrateUp(context.Background())
+	for _, m := range applied {
+		fmt.Println("applied", m)
+	}
+	return err
+}
+
+func (cmd migrateDownCmd) Run(c *config.Configuration) error {
+	db, err := c.NewClient()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	reverted, err := db.MigrateDown(context.Background(), cmd.Steps)
+	for _, m := range reverted {
+		fmt.Println("reverted", m)
+	}
+	return err
+}
+
+func (migrateStatusCmd) Run(c *config.Configuration) error {
+	db, err := c.NewClient()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	status, err := db.MigrationStatus(context.Background())
+	for _, m := range status {
+		if m.IsApplied() {
+			fmt.Printf("%s\tapplied %s\n", m, m.Applied.Format(time.RFC3339))
+		} else {
+			fmt.Printf("%s\tpending\n", m)
+		}
+	}
+	return err
+}
+
+func (cmd migrateCreateCmd) Run() error {
+	up, down, err := data.CreateMigration(cmd.Dir, cmd.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Println("created", up)
+	fmt.Println("created", down)
+	return nil
+}
+
+func (dbBackupCmd) Run(c *config.Configuration) error {
+	db, err := c.NewClient()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	path, err := c.NewBackups(db).Run(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Println("backed up to", path)
+	return nil
+}
+
+func (cmd dbRestoreCmd) Run(c *config.Configuration) error {
+	if err := c.Restore(context.Background(), cmd.Snapshot); err != nil {
+		return err
+	}
+	fmt.Println("restored", cmd.Snapshot, "to", c.Database.Path)
+	return nil
+}
+
+func (cmd dbVerifyCmd) Run() error {
+	if err := data.Verify(context.Background(), cmd.Snapshot); err != nil {
+		return err
+	}
+	fmt.Println(cmd.Snapshot, "ok")
+	return nil
 }
 
 func run(c *config.Configuration) error {
 	logger := c.NewLogger()
 	logger.Debug().Object("config", c).Msg("dumping config")
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	defer cancel()
 
 	if err := c.InitCookie(); err != nil {
 		return err
+	} else if c.Cookie.IsUnset() {
+		logger.Warn().Msg("no cookie secret configured, using a random one")
+	}
+	if c.Cookie.Insecure {
+		logger.Warn().Msg("cookies are sent over plain HTTP")
 	}
-	if err := c.InitOAuth(); err != nil {
+	providers, err := c.NewProviders(ctx)
+	if err != nil {
 		return err
 	} else if c.IsBypassAuth() {
 		logger.Warn().Msg("authentication bypass activated")
@@ -66,19 +205,65 @@ func run(c *config.Configuration) error {
 	if err != nil {
 		return err
 	}
-	db, err := c.NewClient()
+	keys, err := c.EncryptionKeys()
 	if err != nil {
 		return err
+	} else if c.Database.EncryptionKey == "" && len(c.Database.EncryptionKeys) == 0 {
+		logger.Warn().Msg("no database encryption key configured, using a random one")
+	}
+	db, err := c.NewClient(data.WithEncryptionKeys(keys...))
+	if err != nil {
+		return err
+	}
+	if c.AutoMigrate() {
+		applied, err := db.MigrateUp(ctx)
+		for _, m := range applied {
+			logger.Info().Str("migration", m.String()).Msg("applied migration")
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := db.CheckSchema(ctx); err != nil {
+		return err
+	} else if err := db.SetupSearch(ctx); err != nil {
+		return err
+	}
+	if !db.FullTextSearch() {
+		logger.Warn().Msg("SQLite built without FTS5 (-tags sqlite_fts5), searching with LIKE")
+	}
+	if err := c.InitSessions(db); err != nil {
+		return err
+	}
+
+	middlewares := []server.Middleware{server.MiddlewareUser(app.ErrorHandler),
+		server.MiddlewareCSRF(app.ErrorHandler), providers.Middleware}
+	// starred repositories are only copied for the github provider
+	var syncer *starred.Syncer
+	if provider, ok := providers.Get(github.ProviderName); ok {
+		syncer = starred.NewSyncer(db, provider, c.Sync.Opts()...)
+		middlewares = append(middlewares, syncer.Middleware)
 	}
 
 	opts := []server.ServerOption{
 		server.WithLogger(logger),
 		server.WithHost(c.Host),
 		server.WithPort(c.Port),
+		server.WithAdminPort(c.AdminPort),
 		server.WithErrorHandler(app.ErrorHandler),
 		server.WithNotFoundHandler(app.NotFoundHandler),
 		server.WithDatabase(db),
 	}
+	opts = append(opts, c.Shutdown.Opts()...)
+	tlsOpts, err := c.TLS.Opts()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, tlsOpts...)
+	if syncer != nil {
+		// reported only, the starred copy keeps being served while GitHub is
+		// down. Cached, the probes would call GitHub every few seconds otherwise
+		opts = append(opts, server.WithNonGatingCheck("github", server.CachedCheck(github.Reachable, time.Minute)))
+	}
 	if c.Tracer.Enabled {
 		provider, err := telemetry.NewTracerProvider(
 			"d2s",
@@ -93,22 +278,51 @@ func run(c *config.Configuration) error {
 	if err != nil {
 		logger.Fatal().Stack().Err(err).Msg("failed to instanciate server")
 	}
-	base := srv.With(server.MiddlewareUser(app.ErrorHandler))
+	// workers are stopped before the database they rely on
+	if interval := c.Database.Backup.Interval.Duration; interval > 0 {
+		backups := c.NewBackups(db)
+		srv.Register("backups", server.Worker(func(ctx context.Context) { backups.Schedule(ctx, interval) }))
+	}
+	srv.Register("sessions", server.Worker(func(ctx context.Context) {
+		server.PurgeSessions(ctx, db, c.Session.Interval())
+	}))
+	if syncer != nil {
+		srv.Register("syncer", server.Worker(syncer.Run))
+	}
+	// deliveries are authenticated by their signature, neither session nor
+	// CSRF token
+	if c.Webhook.Secret != "" {
+		receiver, err := webhook.NewReceiver(db, c.Webhook.Secret, c.Webhook.Opts()...)
+		if err != nil {
+			return err
+		}
+		srv.HandleStd("/hooks/github", receiver)
+	} else {
+		logger.Info().Msg("no webhook secret configured, not receiving GitHub events")
+	}
+	base := srv.With(middlewares...)
 	base.Get("/", app.Index)
 	base.Post("/", app.IndexPost)
+	base.Get("/repos", app.Repos)
+	base.Post("/collections", app.CollectionCreate)
+	base.Get("/collections/{id}", app.Collection)
+	base.Post("/collections/{id}", app.CollectionPost)
+	base.Post("/collections/{id}/repos", app.CollectionAdd)
+	base.Post("/collections/{id}/repos/{repo}/remove", app.CollectionRemove)
+	base.Post("/collections/{id}/share", app.CollectionShare)
+	base.Post("/collections/{id}/delete", app.CollectionDelete)
+	base.Get("/shared/{token}", app.SharedCollection)
 	base.HandleFunc("/lorem", lorem.Index, cache)
 	base.HandleFunc("/alert", app.Alert)
 	base.HandleFunc("/panic", func(_ *server.Context) error {
 		// w.Write([]byte("I'm about to panic!")) // this will send a response 200 as we write to resp
 		panic("some unknown reason")
 	})
-	if c.IsBypassAuth() {
-		base.HandleFunc("/auth/login", app.LoginBypass)
-	} else {
-		base.HandleFunc("/auth/login", app.Login)
-		base.HandleFunc("/auth/callback", app.Callback)
-	}
-	base.HandleFunc("/auth/logout", app.Logout)
+	base.HandleFunc("/auth/{provider}/login", app.Login)
+	base.HandleFunc("/auth/{provider}/callback", app.Callback)
+	base.HandleFunc("/auth/callback", app.LegacyCallback)
+	base.Post("/auth/logout", app.Logout)
+	base.Post("/auth/logout/all", app.LogoutAll)
 	base.HandleFunc("/error", func(ctx *server.Context) error {
 		app.ErrorHandler(ctx, errors.New("something bad happened"))
 		return nil
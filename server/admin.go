@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/platipy-io/d2s/internal/log"
+)
+
+// newAdminHandler serves the probes, the metrics and the profiles, which are
+// kept off the public port.
+func newAdminHandler(checks []namedCheck) http.Handler {
+	router := chi.NewRouter()
+	router.HandleFunc("/live", healthEndpoint(nil))
+	router.HandleFunc("/ready", healthEndpoint(checks))
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// the index also serves the named profiles: heap, goroutine...
+	router.HandleFunc("/debug/pprof/*", pprof.Index)
+	return router
+}
+
+// adminHook listens on the admin port on start, before the components start
+// so the probes answer meanwhile, and shuts the admin server down last.
+func adminHook(admin *http.Server, logger log.Logger) hook {
+	return hook{name: "admin", start: func(context.Context) error {
+		listener, err := net.Listen("tcp", admin.Addr)
+		if err != nil {
+			return err
+		}
+		logger.Info().Msg("starting admin server on: " + admin.Addr)
+		go func() {
+			if err := admin.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				logger.Err(err).Msg("admin server failed")
+			}
+		}()
+		return nil
+	}, stop: admin.Shutdown}
+}
@@ -8,24 +8,62 @@ import (
 	"net/http"
 
 	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/internal/aead"
 )
 
-var secret []byte
+// secrets holds the keys used to sign cookies, the first one signs new cookies
+// while all of them are accepted when reading, this allows rotating keys.
+var secrets [][]byte
+
+// keyring encrypts cookies with keys derived from the secrets, apart from the
+// signing ones.
+var keyring *aead.Keyring
+
+// secure restricts the cookies to HTTPS, it is only turned off to serve plain
+// HTTP without a TLS terminating proxy in front.
+var secure = true
+
+// SetSecureCookies sets whether cookies are only sent over HTTPS.
+func SetSecureCookies(on bool) {
+	secure = on
+}
+
+// SecureCookies reports whether cookies are only sent over HTTPS.
+func SecureCookies() bool {
+	return secure
+}
 
 var (
 	ErrValueTooLong       = xerrors.Message("cookie value too long")
 	ErrInvalidValue       = xerrors.Message("invalid cookie value")
 	ErrAlreadyInitialized = xerrors.Message("secret already set")
+	ErrMissingSecret      = xerrors.Message("at least one secret is required")
 )
 
-func InitCookieStore(token []byte) error {
-	if secret != nil {
+// InitCookieStore sets the signing keys, newest first.
+func InitCookieStore(keys ...[]byte) error {
+	if secrets != nil {
 		return ErrAlreadyInitialized
 	}
-	secret = token
+	if len(keys) == 0 {
+		return ErrMissingSecret
+	}
+	ring, err := aead.New("d2s cookie encryption", keys...)
+	if err != nil {
+		return err
+	}
+	secrets, keyring = keys, ring
 	return nil
 }
 
+func sign(key []byte, name, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
 func Write(w http.ResponseWriter, cookie http.Cookie) error {
 	// Encode the cookie value using base64.
 	cookie.Value = base64.URLEncoding.EncodeToString([]byte(cookie.Value))
@@ -63,11 +101,8 @@ func Read(r *http.Request, name string) (string, error) {
 
 func WriteSigned(w http.ResponseWriter, cookie http.Cookie) error {
 	// Calculate a HMAC signature of the cookie name and value, using SHA256 and
-	// a secret key (which we will create in a moment).
-	mac := hmac.New(sha256.New, secret)
-	mac.Write([]byte(cookie.Name))
-	mac.Write([]byte(cookie.Value))
-	signature := mac.Sum(nil)
+	// the newest secret key.
+	signature := sign(secrets[0], cookie.Name, cookie.Value)
 
 	// Prepend the cookie value with the HMAC signature.
 	cookie.Value = string(signature) + cookie.Value
@@ -98,19 +133,46 @@ func ReadSigned(r *http.Request, name string) (string, error) {
 	signature := signedValue[:sha256.Size]
 	value := signedValue[sha256.Size:]
 
-	// Recalculate the HMAC signature of the cookie name and original value.
-	mac := hmac.New(sha256.New, secret)
-	mac.Write([]byte(name))
-	mac.Write([]byte(value))
-	expectedSignature := mac.Sum(nil)
+	// Recalculate the HMAC signature of the cookie name and original value
+	// with each known key and check that one of them matches the signature we
+	// received in the cookie. If they match, we can be confident that the
+	// cookie name and value haven't been edited by the client.
+	for _, key := range secrets {
+		if hmac.Equal([]byte(signature), sign(key, name, value)) {
+			// Return the original cookie value.
+			return value, nil
+		}
+	}
+	return "", ErrInvalidValue
+}
 
-	// Check that the recalculated signature matches the signature we received
-	// in the cookie. If they match, we can be confident that the cookie name
-	// and value haven't been edited by the client.
-	if !hmac.Equal([]byte(signature), expectedSignature) {
-		return "", ErrInvalidValue
+func WriteEncrypted(w http.ResponseWriter, cookie http.Cookie) error {
+	// Encrypt and authenticate the value, the client can neither read nor
+	// modify it.
+	encrypted, err := keyring.Seal(cookie.Name, []byte(cookie.Value))
+	if err != nil {
+		return err
+	}
+	cookie.Value = string(encrypted)
+
+	// Call our Write() helper to base64-encode the new cookie value and write
+	// the cookie.
+	return Write(w, cookie)
+}
+
+func ReadEncrypted(r *http.Request, name string) (string, error) {
+	// Read in the encrypted value from the cookie. This should be in the format
+	// "{nonce}{encrypted value}".
+	encrypted, err := Read(r, name)
+	if err != nil {
+		return "", err
 	}
 
-	// Return the original cookie value.
-	return value, nil
+	// Decrypt the value, this fails if it has been tampered with or was
+	// encrypted with an unknown secret.
+	value, err := keyring.Open(name, []byte(encrypted))
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+	return string(value), nil
 }
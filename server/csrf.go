@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+const (
+	csrfCookieName = "csrf"
+	csrfTokenSize  = 32
+
+	// CSRFHeader is the header HTMX requests carry the token in.
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField is the form field regular forms carry the token in.
+	CSRFField = "csrf_token"
+)
+
+var (
+	ErrCSRF         = xerrors.Message("invalid csrf token")
+	ErrCSRFGenerate = xerrors.Message("failed to generate csrf token")
+)
+
+type csrfKey struct{}
+
+func newCSRFCookie() http.Cookie {
+	return http.Cookie{Name: csrfCookieName, Path: "/",
+		HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", xerrors.WithWrapper(ErrCSRFGenerate, err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// MiddlewareCSRF issues a per session token stored in a signed cookie and
+// checks it against the X-CSRF-Token header (or the csrf_token form field) on
+// unsafe methods and HTMX requests.
+func MiddlewareCSRF(errHandler func(*Context, error)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := ReadSigned(r, csrfCookieName)
+			if err != nil {
+				if token, err = newCSRFToken(); err == nil {
+					cookie := newCSRFCookie()
+					cookie.Value = token
+					err = WriteSigned(w, cookie)
+				}
+				if err != nil {
+					errHandler(NewContext(w, r), err)
+					return
+				}
+			}
+			r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, token))
+
+			if _, htmx := r.Header["Hx-Request"]; !isSafeMethod(r.Method) || htmx {
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = r.PostFormValue(CSRFField)
+				}
+				if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					errHandler(NewContext(w, r), ErrCSRF)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRFToken returns the token issued by MiddlewareCSRF for this request.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
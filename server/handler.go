@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/a-h/templ"
+	"github.com/go-chi/chi/v5"
 	"github.com/platipy-io/d2s/data"
 	"github.com/platipy-io/d2s/internal/log"
 	"github.com/platipy-io/d2s/internal/telemetry"
@@ -39,22 +40,42 @@ func (c *Context) Redirect(url string, code int) {
 	http.Redirect(c.ResponseWriter, c.Request, url, code)
 }
 
+// SetCookie sets a plain cookie, not readable from scripts and only sent over
+// HTTPS unless SetSecureCookies turned it off. Use WriteSigned or WriteEncrypted for values which must be trusted.
 func (c *Context) SetCookie(name, value string, duration time.Duration) {
-	cookie := http.Cookie{Name: name, Value: value, Expires: time.Now().Add(duration)}
+	cookie := http.Cookie{Name: name, Value: value, Path: "/",
+		Expires: time.Now().Add(duration), MaxAge: int(duration.Seconds()),
+		HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode}
 	http.SetCookie(c.ResponseWriter, &cookie)
 }
 
+// URLParam returns the value of the {key} placeholder of the route pattern.
+func (c *Context) URLParam(key string) string {
+	return chi.URLParam(c.Request, key)
+}
+
+func (c *Context) CSRFToken() string {
+	return CSRFToken(c.Request)
+}
+
 func (c *Context) Render(component templ.Component) error {
 	return component.Render(c.Context(), c.ResponseWriter)
 }
 
 func (c *Context) SetUser() error {
-	return SetCookieUser(c.ResponseWriter, c.User)
+	return SetCookieUser(c.ResponseWriter, c.Request, c.User)
+}
+
+func (c *Context) DeleteUser() error {
+	c.User = nil
+	return DeleteCookieUser(c.ResponseWriter, c.Request)
 }
 
-func (c *Context) DeleteUser() {
+// DeleteUserSessions logs the user out of every device.
+func (c *Context) DeleteUserSessions() error {
+	user := c.User
 	c.User = nil
-	DeleteCookieUser(c.ResponseWriter)
+	return DeleteUserSessions(c.ResponseWriter, c.Request, user)
 }
 
 type Handler interface {
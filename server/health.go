@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// checkTimeout bounds each check of a probe.
+const checkTimeout = 5 * time.Second
+
+// HealthCheck reports whether a dependency of the server works.
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+	// non gating checks are only reported, they never fail the probe
+	gating bool
+}
+
+var checkUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "health_check_up",
+	Help: "Whether the last run of a readiness check succeeded (1) or failed (0), partitioned by check.",
+}, []string{"check"})
+
+func init() {
+	prometheus.MustRegister(checkUp)
+}
+
+// CachedCheck runs check at most once per ttl and reports its last result in
+// between, for dependencies which should not be called on every probe.
+func CachedCheck(check HealthCheck, ttl time.Duration) HealthCheck {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		last    error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(checked) >= ttl {
+			last, checked = check(ctx), time.Now()
+		}
+		return last
+	}
+}
+
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// healthReport is served to ?verbose probes.
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func status(err error) string {
+	if err != nil {
+		return "failing"
+	}
+	return "ok"
+}
+
+func up(err error) float64 {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// healthEndpoint runs the checks concurrently and answers 503 if any gating
+// one failed, the details of each one are reported with the verbose
+// parameter and their result exported as the health_check_up metric.
+func healthEndpoint(checks []namedCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{Status: "ok", Checks: make(map[string]checkResult, len(checks))}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+				defer cancel()
+				start := time.Now()
+				err := c.check(ctx)
+				result := checkResult{Status: status(err), Latency: time.Since(start).String()}
+				checkUp.WithLabelValues(c.name).Set(up(err))
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					result.Error = err.Error()
+					if c.gating {
+						report.Status = status(err)
+					}
+				}
+				report.Checks[c.name] = result
+			}()
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		if report.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if !r.URL.Query().Has("verbose") {
+			http.Error(w, report.Status, code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	}
+}
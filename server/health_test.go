@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestReadiness(t *testing.T) {
+	failing := func(context.Context) error { return errors.New("unreachable") }
+	passing := func(context.Context) error { return nil }
+	for _, tc := range []struct {
+		name   string
+		checks []namedCheck
+		code   int
+		status string
+	}{
+		{name: "passing", checks: []namedCheck{
+			{name: "database", check: passing, gating: true},
+			{name: "github", check: passing},
+		}, code: http.StatusOK, status: "ok"},
+		{name: "non gating failing", checks: []namedCheck{
+			{name: "database", check: passing, gating: true},
+			{name: "github", check: failing},
+		}, code: http.StatusOK, status: "ok"},
+		{name: "gating failing", checks: []namedCheck{
+			{name: "database", check: failing, gating: true},
+			{name: "github", check: passing},
+		}, code: http.StatusServiceUnavailable, status: "failing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newAdminHandler(tc.checks)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready?verbose", nil))
+			if rec.Code != tc.code {
+				t.Errorf("expected %d, got %d", tc.code, rec.Code)
+			}
+			var report healthReport
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tc.status {
+				t.Errorf("expected %s, got %s", tc.status, report.Status)
+			}
+			// every check is reported and exported, gating or not
+			for _, c := range tc.checks {
+				err := c.check(context.Background())
+				if got := report.Checks[c.name].Status; got != status(err) {
+					t.Errorf("expected %s %s, got %s", c.name, status(err), got)
+				}
+				var metric dto.Metric
+				if err := checkUp.WithLabelValues(c.name).Write(&metric); err != nil {
+					t.Fatal(err)
+				}
+				if got := metric.GetGauge().GetValue(); got != up(err) {
+					t.Errorf("expected %s metric %v, got %v", c.name, up(err), got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/internal/log"
+)
+
+const (
+	// startTimeout bounds each start hook.
+	startTimeout = 30 * time.Second
+	// stopTimeout bounds each stop hook, the buffered spans have this long to
+	// be flushed, the database to be closed...
+	stopTimeout = 5 * time.Second
+)
+
+var (
+	ErrStartup  = xerrors.Message("failed starting")
+	ErrShutdown = xerrors.Message("failed shutting down")
+)
+
+// Component is started along with the server and stopped on its shutdown.
+type Component interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// hook is a unit of startup and shutdown work, either can be nil. The stop
+// deadline defaults to stopTimeout.
+type hook struct {
+	name        string
+	start, stop func(context.Context) error
+	deadline    time.Duration
+}
+
+// Lifecycle starts the registered hooks in order and stops them in reverse
+// order, so a hook can rely on the ones registered before it.
+type Lifecycle struct {
+	logger log.Logger
+	// mu holds the shutdown until the startup is over.
+	mu      sync.Mutex
+	hooks   []hook
+	started int
+}
+
+func (l *Lifecycle) append(h hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, h)
+}
+
+// Start runs the start hooks in order. On failure, the hooks already started
+// are stopped and their failures are returned along.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, h := range l.hooks[l.started:] {
+		if h.start != nil {
+			if err := l.run(ctx, "start", h.name, startTimeout, h.start); err != nil {
+				return l.stop(ctx, xerrors.New(ErrStartup, h.name, err))
+			}
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Stop runs the stop hooks of the started hooks in reverse order. Every one
+// runs whatever the failures of the others, which are aggregated.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stop(ctx, nil)
+}
+
+// stop appends the failures of the stop hooks to err.
+func (l *Lifecycle) stop(ctx context.Context, err error) error {
+	for ; l.started > 0; l.started-- {
+		h := l.hooks[l.started-1]
+		deadline := h.deadline
+		if deadline == 0 {
+			deadline = stopTimeout
+		}
+		if h.stop != nil {
+			if stopErr := l.run(ctx, "stop", h.name, deadline, h.stop); stopErr != nil {
+				err = xerrors.Append(err, xerrors.New(ErrShutdown, h.name, stopErr))
+			}
+		}
+	}
+	return err
+}
+
+// run runs a hook within its deadline and logs its outcome. The hook is given
+// up on once its deadline is exceeded, even if it does not watch its context.
+func (l *Lifecycle) run(ctx context.Context, verb, name string, deadline time.Duration,
+	fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	logger := l.logger.With().Str("hook", name).Str("step", verb).Dur("elapsed", time.Since(start)).Logger()
+	if err != nil {
+		logger.Error().Err(err).Msg("hook failed")
+		return err
+	}
+	logger.Info().Msg("hook done")
+	return nil
+}
+
+// worker runs a function in the background between the start and the stop of
+// the lifecycle.
+type worker struct {
+	run    func(ctx context.Context)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Worker turns a function running until its context is canceled, like a
+// scheduler, into a component.
+func Worker(run func(ctx context.Context)) Component {
+	return &worker{run: run}
+}
+
+func (w *worker) Start(ctx context.Context) error {
+	// the context of the start hook is canceled as soon as it returns
+	ctx, w.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(ctx)
+	}()
+	return nil
+}
+
+// Stop cancels the worker and waits for it to return.
+func (w *worker) Stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -29,20 +29,35 @@ func MiddlewareRecover(next http.Handler) http.Handler {
 func MiddlewareUser(errHandler func(*Context, error)) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user, err := GetCookieUser(r)
+			ctx := r.Context()
+			id, session, user, err := getCookieSession(r)
 			switch {
 			case err == nil:
 				r = SetUser(r, user)
+				if err := refreshSession(w, r, id, session); err != nil {
+					log.Ctx(ctx).Error().Ctx(ctx).Err(err).Msg("failed refreshing session")
+				}
 			case errors.Is(err, http.ErrNoCookie):
 				// pass without setting anything
+			case errors.Is(err, ErrSessionNotFound):
+				// session expired or was revoked
+				expireCookie(w, cookieName)
 			case errors.Is(err, ErrInvalidValue):
 				// maybe 400
 				errHandler(NewContext(w, r), err)
 				return
 			default:
-				ctx := r.Context()
 				log.Ctx(ctx).Error().Ctx(ctx).Err(err).Msg("uncaught error")
 			}
+			if _, err := r.Cookie(legacyCookieName); err != nil {
+				// nothing to migrate
+			} else if GetUser(r) != nil {
+				expireCookie(w, legacyCookieName)
+			} else if user, err := migrateLegacyCookie(w, r); err != nil {
+				log.Ctx(ctx).Warn().Ctx(ctx).Err(err).Msg("dropped legacy session cookie")
+			} else {
+				r = SetUser(r, user)
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemContentType is the media type of the RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem details an error to the clients asking for JSON (RFC 7807).
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// NewProblem describes the status of the request, Type is left to its
+// "about:blank" default as the status tells everything about the problem.
+func NewProblem(r *http.Request, status int, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status,
+		Detail: detail, Instance: r.URL.Path}
+}
+
+// WantsJSON reports whether the client prefers JSON over HTML, according to
+// its Accept header. Wildcards are served HTML, the default representation,
+// unless JSON is explicitly accepted as well.
+func WantsJSON(r *http.Request) bool {
+	json, html, wildcard := 0.0, 0.0, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if value, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			json = max(json, quality)
+		case mediaType == "text/html":
+			html = max(html, quality)
+		case mediaType == "text/*" || mediaType == "*/*":
+			wildcard = max(wildcard, quality)
+		}
+	}
+	return json > html && json >= wildcard
+}
+
+// IsHTMX reports whether the request was issued by htmx, which swaps the
+// response in the page rather than loading it.
+func IsHTMX(r *http.Request) bool {
+	_, ok := r.Header["Hx-Request"]
+	return ok
+}
+
+// WriteProblem writes the problem with its status.
+func (c *Context) WriteProblem(problem Problem) error {
+	c.ResponseWriter.Header().Set("Content-Type", ProblemContentType)
+	c.ResponseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+	c.WriteHeader(problem.Status)
+	return json.NewEncoder(c.ResponseWriter).Encode(problem)
+}
+
+// writeStatus answers with the text of status, as problem details to the
+// clients asking for JSON.
+func writeStatus(ctx *Context, status int) {
+	if WantsJSON(ctx.Request) {
+		if err := ctx.WriteProblem(NewProblem(ctx.Request, status, "")); err != nil {
+			ctx.Logger.Error().Ctx(ctx.Context()).Err(err).Msg("failed writing problem")
+		}
+		return
+	}
+	http.Error(ctx.ResponseWriter, http.StatusText(status), status)
+}
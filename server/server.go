@@ -2,24 +2,29 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/heptiolabs/healthcheck"
 	"github.com/mdobak/go-xerrors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/platipy-io/d2s/data"
 	"github.com/platipy-io/d2s/internal/log"
 	"github.com/platipy-io/d2s/internal/telemetry"
 )
 
+// timeout bounds the draining of the in-flight requests on shutdown.
 var timeout = 30 * time.Second
 
 type Middleware = func(http.Handler) http.Handler
@@ -32,24 +37,32 @@ type serverConfig struct {
 	tracerProvider  *telemetry.TracerProvider
 	errorHandler    func(*Context, error)
 	notFoundHandler func(*Context)
+	preStopDelay    time.Duration
+	checks          []namedCheck
+	adminPort       int
+	tlsConfig       *tls.Config
+	certReloader    *CertReloader
+	h2c             bool
 }
 
 func defaultErrorHandler(ctx *Context, err error) {
 	ctx.Logger.Error().Ctx(ctx.Context()).Stack().Err(err).Msg("handling error")
-	ctx.WriteHeader(http.StatusInternalServerError)
-	ctx.ResponseWriter.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+	writeStatus(ctx, http.StatusInternalServerError)
 }
 
 func defaultNotFoundHandler(ctx *Context) {
 	ctx.Logger.Warn().Ctx(ctx.Context()).Msg("page not found")
-	ctx.WriteHeader(http.StatusNotFound)
-	ctx.ResponseWriter.Write([]byte(http.StatusText(http.StatusNotFound)))
+	writeStatus(ctx, http.StatusNotFound)
 }
 
 func (sc serverConfig) addr() string {
 	return sc.host + ":" + strconv.Itoa(sc.port)
 }
 
+func (sc serverConfig) adminAddr() string {
+	return sc.host + ":" + strconv.Itoa(sc.adminPort)
+}
+
 // ServerOption applies a configuration option value to a Server.
 type ServerOption interface {
 	apply(serverConfig) serverConfig
@@ -62,7 +75,7 @@ func (fn ServerOptionFunc) apply(c serverConfig) serverConfig {
 }
 
 func newServerConfig(opts []ServerOption) serverConfig {
-	sc := serverConfig{port: 8080, logger: log.Nop()}
+	sc := serverConfig{port: 8080, adminPort: 9090, logger: log.Nop()}
 	for _, opt := range opts {
 		sc = opt.apply(sc)
 	}
@@ -90,6 +103,43 @@ func WithPort(port int) ServerOption {
 	})
 }
 
+// WithAdminPort sets the port serving the probes (/live, /ready), the metrics
+// and the profiles (/debug/pprof), the public port only serves the routes of
+// the application.
+func WithAdminPort(port int) ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.adminPort = port
+		return sc
+	})
+}
+
+// WithTLS serves HTTPS on the port with config, HTTP/2 is negotiated with the
+// clients supporting it. The admin port keeps serving plain HTTP.
+func WithTLS(config *tls.Config) ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.tlsConfig = config
+		return sc
+	})
+}
+
+// WithCertReloader serves the certificate of reloader over TLS, and keeps it
+// up to date while the server runs.
+func WithCertReloader(reloader *CertReloader) ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.certReloader = reloader
+		return sc
+	})
+}
+
+// WithH2C serves HTTP/2 without TLS (h2c), for a proxy such as a service mesh
+// sidecar talking to the server over plain HTTP/2.
+func WithH2C() ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.h2c = true
+		return sc
+	})
+}
+
 func WithTracerProvider(provider *telemetry.TracerProvider) ServerOption {
 	return ServerOptionFunc(func(sc serverConfig) serverConfig {
 		sc.tracerProvider = provider
@@ -104,6 +154,26 @@ func WithErrorHandler(handler func(*Context, error)) ServerOption {
 	})
 }
 
+// WithHealthCheck adds a check to the readiness probe, failing it takes the
+// server out of the load balancer. The liveness probe does not run the checks:
+// restarting the server would not fix its dependencies.
+func WithHealthCheck(name string, check HealthCheck) ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.checks = append(sc.checks, namedCheck{name: name, check: check, gating: true})
+		return sc
+	})
+}
+
+// WithNonGatingCheck adds a check which is only reported by the readiness
+// probe, with the verbose parameter and as a metric. For dependencies the
+// server keeps serving without, it stays ready when they fail.
+func WithNonGatingCheck(name string, check HealthCheck) ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.checks = append(sc.checks, namedCheck{name: name, check: check})
+		return sc
+	})
+}
+
 func WithNotFoundHandler(handler func(*Context)) ServerOption {
 	return ServerOptionFunc(func(sc serverConfig) serverConfig {
 		sc.notFoundHandler = handler
@@ -111,6 +181,15 @@ func WithNotFoundHandler(handler func(*Context)) ServerOption {
 	})
 }
 
+// WithPreStopDelay sets how long the server keeps serving once it is not ready
+// anymore, letting the load balancer notice before the requests are drained.
+func WithPreStopDelay(delay time.Duration) ServerOption {
+	return ServerOptionFunc(func(sc serverConfig) serverConfig {
+		sc.preStopDelay = delay
+		return sc
+	})
+}
+
 func WithDatabase(database *data.DB) ServerOption {
 	return ServerOptionFunc(func(sc serverConfig) serverConfig {
 		sc.database = database
@@ -125,18 +204,33 @@ type (
 		logger       log.Logger
 		database     *data.DB
 		errorHandler func(*Context, error)
+		lifecycle    *Lifecycle
+		preStopDelay time.Duration
+		tls          bool
+		// draining fails the readiness check once the shutdown started.
+		draining *atomic.Bool
 	}
 )
 
-var ErrDatabaseNotProvided = xerrors.Message("database not provided")
+var (
+	ErrDatabaseNotProvided = xerrors.Message("database not provided")
+	ErrShuttingDown        = xerrors.Message("server is shutting down")
+	ErrAdminPort           = xerrors.Message("admin port must differ from the port")
+)
 
 func NewServer(opts ...ServerOption) (*Server, error) {
-	health := healthcheck.NewHandler()
 	config := newServerConfig(opts)
 	router := chi.NewRouter()
 	logger := config.logger
 	notFoundHandler := defaultNotFoundHandler
 	errorHandler := defaultErrorHandler
+	draining := &atomic.Bool{}
+	checks := []namedCheck{{name: "shutdown", gating: true, check: func(context.Context) error {
+		if draining.Load() {
+			return ErrShuttingDown
+		}
+		return nil
+	}}}
 
 	middlewares := []Middleware{
 		MiddlewareMetrics, MiddlewareLogger(logger), MiddlewareRecover,
@@ -146,7 +240,9 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 		tracerMiddleware := MiddlewareOpenTelemetry("server",
 			otelhttp.WithTracerProvider(config.tracerProvider))
 		endpoint := config.tracerProvider.Endpoint()
-		health.AddReadinessCheck("tracer", healthcheck.TCPDialCheck(endpoint, 5*time.Second))
+		dial := healthcheck.TCPDialCheck(endpoint, checkTimeout)
+		// losing traces is no reason to stop serving
+		checks = append(checks, namedCheck{name: "tracer", check: func(context.Context) error { return dial() }})
 		middlewares = append([]Middleware{tracerMiddleware}, middlewares...)
 	}
 
@@ -162,21 +258,62 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 		return nil, ErrDatabaseNotProvided
 	}
 
-	router.HandleFunc("/live", health.LiveEndpoint)
-	router.HandleFunc("/ready", health.ReadyEndpoint)
-	router.Handle("/metrics", promhttp.Handler())
+	if config.adminPort == config.port {
+		return nil, xerrors.New(ErrAdminPort, config.port)
+	}
+
+	tlsConfig := config.tlsConfig
+	if config.certReloader != nil {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.GetCertificate = config.certReloader.GetCertificate
+	}
+	if tlsConfig != nil {
+		if config.h2c {
+			return nil, ErrH2CWithTLS
+		}
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	checks = append(checks, namedCheck{name: "database", check: config.database.HealthCheck, gating: true})
+	checks = append(checks, config.checks...)
+
 	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		ctx := NewContext(w, r)
 		notFoundHandler(ctx)
 		defer xerrors.Recover(func(err error) { errorHandler(ctx, err) })
 	})
 
+	// stopped in reverse order, after everything registered later on
+	lifecycle := &Lifecycle{logger: logger}
+	admin := &http.Server{Addr: config.adminAddr(), Handler: newAdminHandler(checks)}
+	lifecycle.append(adminHook(admin, logger))
+	lifecycle.append(hook{name: "database", stop: func(context.Context) error { return config.database.Close() }})
+	if config.tracerProvider != nil {
+		lifecycle.append(hook{name: "tracer", stop: config.tracerProvider.Shutdown})
+	}
+	if config.certReloader != nil {
+		reloader := Worker(config.certReloader.Run)
+		lifecycle.append(hook{name: "certificates", start: reloader.Start, stop: reloader.Stop})
+	}
+
+	var handler http.Handler = router
+	if config.h2c {
+		handler = h2c.NewHandler(router, &http2.Server{})
+	}
+
 	return &Server{
-		server:       &http.Server{Addr: config.addr(), Handler: router},
+		server:       &http.Server{Addr: config.addr(), Handler: handler, TLSConfig: tlsConfig},
 		router:       router.Route("/", func(r chi.Router) { r.Use(middlewares...) }),
 		logger:       logger,
 		errorHandler: errorHandler,
 		database:     config.database,
+		lifecycle:    lifecycle,
+		preStopDelay: config.preStopDelay,
+		tls:          tlsConfig != nil,
+		draining:     draining,
 	}, nil
 }
 
@@ -207,9 +344,26 @@ func (s *Server) HandleStd(pattern string, handler http.Handler, middlewares ...
 	}
 }
 
+// OnStart registers work to run before the server starts listening.
+func (s *Server) OnStart(name string, fn func(context.Context) error) {
+	s.lifecycle.append(hook{name: name, start: fn})
+}
+
+// OnStop registers work to run on shutdown, once the requests are drained.
+func (s *Server) OnStop(name string, fn func(context.Context) error) {
+	s.lifecycle.append(hook{name: name, stop: fn})
+}
+
+// Register starts the component with the server and stops it on shutdown,
+// before the components registered earlier.
+func (s *Server) Register(name string, component Component) {
+	s.lifecycle.append(hook{name: name, start: component.Start, stop: component.Stop})
+}
+
 func (s *Server) With(middlewares ...Middleware) *Server {
-	return &Server{server: s.server, router: s.router.With(middlewares...),
-		logger: s.logger, errorHandler: s.errorHandler, database: s.database}
+	with := *s
+	with.router = s.router.With(middlewares...)
+	return &with
 }
 
 func (s *Server) Get(pattern string, handler HandlerFunc) {
@@ -220,24 +374,39 @@ func (s *Server) Post(pattern string, handler HandlerFunc) {
 	s.router.Post(pattern, s.stdHandler(handler))
 }
 
+// Start starts the admin server and the registered components, then serves
+// until the process is interrupted or terminated (SIGTERM, as sent by
+// Kubernetes) and shuts both servers down.
 func (s *Server) Start() error {
-	errChan := make(chan error)
+	ctx := s.logger.WithContext(context.Background())
+	// registered last, the requests are drained before anything else stops
+	s.lifecycle.append(hook{name: "server", stop: s.server.Shutdown, deadline: timeout})
+	if err := s.lifecycle.Start(ctx); err != nil {
+		s.logger.Err(err).Msg("failed to start server")
+		return err
+	}
+	errChan := make(chan error, 1)
 
 	go func() {
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
-		<-sigint
-		s.logger.Info().Msg("received interrupt, closing server...")
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		errChan <- xerrors.New(s.server.Shutdown(ctx))
-		cancel()
-		close(errChan)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		// a second signal kills the process without waiting for the drain
+		signal.Stop(signals)
+		s.logger.Info().Str("signal", sig.String()).Msg("received signal, closing server...")
+		errChan <- s.shutdown(ctx)
 	}()
-	s.logger.Info().Msg("starting server on: " + s.server.Addr)
-	err := s.server.ListenAndServe()
+	s.logger.Info().Bool("tls", s.tls).Msg("starting server on: " + s.server.Addr)
+	var err error
+	if s.tls {
+		// the certificate comes from the TLS configuration
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		err = s.server.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		s.logger.Err(err).Msg("failed to start server")
-		return err
+		return xerrors.Append(err, s.lifecycle.Stop(ctx))
 	}
 	if err := <-errChan; err != nil {
 		s.logger.Err(err).Msg("failed to stop server")
@@ -246,3 +415,16 @@ func (s *Server) Start() error {
 	s.logger.Info().Msg("server stopped properly")
 	return nil
 }
+
+// shutdown stops the server in order: the readiness check fails first, the
+// in-flight requests are drained once the pre-stop delay let the load
+// balancer notice, then the components are stopped, the database and the
+// admin server last.
+func (s *Server) shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	if s.preStopDelay > 0 {
+		s.logger.Info().Dur("delay", s.preStopDelay).Msg("not ready anymore, waiting before draining")
+		time.Sleep(s.preStopDelay)
+	}
+	return s.lifecycle.Stop(ctx)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
+	"github.com/platipy-io/d2s/internal/log"
+	"github.com/platipy-io/d2s/types"
+)
+
+const sessionIDSize = 32
+
+// SessionStore persists sessions server side, the client only holds an opaque
+// identifier. Implementations return an error matching ErrSessionNotFound
+// from GetSession when the session does not exist or has expired, and from
+// GetUserByID when the user of a session is gone.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *types.Session) error
+	GetSession(ctx context.Context, id string) (*types.Session, error)
+	TouchSession(ctx context.Context, id string, expires time.Time) error
+	DeleteSession(ctx context.Context, id string) error
+	DeleteSessions(ctx context.Context, userID int64) error
+	PurgeSessions(ctx context.Context, now time.Time) (int64, error)
+	GetUserByID(ctx context.Context, id int64) (*types.User, error)
+}
+
+// LegacyStore is implemented by the session stores able to turn the cookies
+// issued before sessions were stored server side into sessions, those cookies
+// are dropped otherwise. GetUserByEmail and GetToken return an error matching
+// ErrSessionNotFound when there is no such user or token.
+type LegacyStore interface {
+	GetUserByEmail(ctx context.Context, email string) (*types.User, error)
+	GetToken(ctx context.Context, userID int64, provider string) (*oauth2.Token, error)
+	SaveToken(ctx context.Context, userID int64, provider string, token *oauth2.Token) error
+}
+
+var (
+	sessions SessionStore
+	// legacy is the session store when it implements LegacyStore
+	legacy     LegacyStore
+	sessionTTL time.Duration
+)
+
+var (
+	ErrSession         = xerrors.Message("session store failure")
+	ErrSessionGenerate = xerrors.Message("failed to generate session identifier")
+	ErrSessionNotFound = xerrors.Message("session not found")
+)
+
+// InitSessionStore sets the store backing the user sessions, ttl is the
+// inactivity period after which a session expires.
+func InitSessionStore(store SessionStore, ttl time.Duration) error {
+	if sessions != nil {
+		return ErrAlreadyInitialized
+	}
+	sessions, sessionTTL = store, ttl
+	legacy, _ = store.(LegacyStore)
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", xerrors.WithWrapper(ErrSessionGenerate, err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSessionID returns the key a session is stored under, so a leaked
+// database can't be used to impersonate clients.
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// PurgeSessions removes the expired sessions of store every interval until the
+// context is done.
+func PurgeSessions(ctx context.Context, store SessionStore, interval time.Duration) {
+	logger := log.Ctx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if count, err := store.PurgeSessions(ctx, now); err != nil {
+				logger.Error().Ctx(ctx).Stack().Err(err).Msg("failed purging sessions")
+			} else {
+				logger.Debug().Ctx(ctx).Int64("count", count).Msg("purged expired sessions")
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/platipy-io/d2s/internal/log"
+)
+
+// DefaultReloadInterval is how often the certificate files are checked for
+// changes.
+const DefaultReloadInterval = time.Minute
+
+var (
+	ErrCertificate = xerrors.Message("failed loading certificate")
+	ErrH2CWithTLS  = xerrors.Message("h2c only applies to plain HTTP, not to TLS")
+)
+
+// CertReloader serves the certificate of a key pair, loaded again when its
+// files change. Rotations, by cert-manager for instance, are picked up without
+// restarting.
+type CertReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// NewCertReloader loads the key pair, an error is returned if it is invalid.
+// The files are checked every interval once Run is started.
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if _, err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// modTime returns the latest modification of the key pair, the files are
+// followed when they are symbolic links as in mounted Kubernetes secrets.
+func (cr *CertReloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, xerrors.New(ErrCertificate, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the key pair again if its files changed since the last load,
+// the current certificate is kept when the new one is invalid.
+func (cr *CertReloader) reload() (bool, error) {
+	modified, err := cr.modTime()
+	if err != nil {
+		return false, err
+	}
+	cr.mu.RLock()
+	unchanged := modified.Equal(cr.modified)
+	cr.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return false, xerrors.New(ErrCertificate, cr.certFile, err)
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert, cr.modified = &cert, modified
+	return true, nil
+}
+
+// Run checks the files every interval until the context is canceled.
+func (cr *CertReloader) Run(ctx context.Context) {
+	logger := log.Ctx(ctx)
+	ticker := time.NewTicker(cr.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// a pair half written is invalid, it is loaded on the next tick
+			if reloaded, err := cr.reload(); err != nil {
+				logger.Error().Ctx(ctx).Err(err).Msg("failed reloading certificate")
+			} else if reloaded {
+				logger.Info().Ctx(ctx).Str("cert", cr.certFile).Msg("reloaded certificate")
+			}
+		}
+	}
+}
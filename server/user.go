@@ -4,63 +4,225 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/mdobak/go-xerrors"
+	"golang.org/x/oauth2"
+
 	"github.com/platipy-io/d2s/types"
 )
 
 const (
-	cookieName = "session"
+	cookieName = "sid"
+	// legacyCookieName held the whole encrypted user before sessions were
+	// stored server side.
+	legacyCookieName = "session"
+	// sessionLabel authenticates the encrypted data of stored sessions.
+	sessionLabel = "session"
 )
 
 var (
 	ErrEncodeUser   = xerrors.Message("failed encoding user")
 	ErrDecodingUser = xerrors.Message("failed decoding user")
+	ErrUnsavedUser  = xerrors.Message("user must be saved before opening a session")
 )
 
+// sessionData is what a session knows about the login on top of the stored
+// user, tokens are kept in the database.
+type sessionData struct {
+	Provider string
+}
+
+// legacyUser is what the cookies issued before sessions were stored server
+// side held, they all came from a GitHub login.
+type legacyUser struct {
+	Name  string
+	Email string
+	Token string
+}
+
+const legacyProvider = "github"
+
 type userKey struct{}
 
 func newCookieUser() http.Cookie {
 	return http.Cookie{Name: cookieName, Path: "/",
-		HttpOnly: true, Secure: true, SameSite: http.SameSiteStrictMode,
+		HttpOnly: true, Secure: secure, SameSite: http.SameSiteStrictMode,
 	}
 }
 
-func SetCookieUser(resp http.ResponseWriter, user *types.User) error {
-	buf := bytes.Buffer{}
-
-	if err := gob.NewEncoder(&buf).Encode(user); err != nil {
-		xerrors.WithWrapper(ErrEncodeUser, err)
-	}
-
+func writeCookieUser(resp http.ResponseWriter, id string) error {
 	cookie := newCookieUser()
-	cookie.Value, cookie.MaxAge = buf.String(), 3600
+	cookie.Value, cookie.MaxAge = id, int(sessionTTL.Seconds())
 	return WriteSigned(resp, cookie)
 }
 
-func DeleteCookieUser(resp http.ResponseWriter) {
+func expireCookie(resp http.ResponseWriter, name string) {
 	cookie := newCookieUser()
-	cookie.MaxAge = -1
+	cookie.Name, cookie.MaxAge = name, -1
 	http.SetCookie(resp, &cookie)
 }
 
+// SetCookieUser opens a new session for user, which must have been saved.
+// Any session the request was carrying is dropped so an identifier set before
+// login can't be reused.
+func SetCookieUser(resp http.ResponseWriter, req *http.Request, user *types.User) error {
+	ctx := req.Context()
+	buf := bytes.Buffer{}
+
+	if user.ID == 0 {
+		return ErrUnsavedUser
+	}
+	payload := sessionData{Provider: user.Provider}
+	if err := gob.NewEncoder(&buf).Encode(payload); err != nil {
+		return xerrors.WithWrapper(ErrEncodeUser, err)
+	}
+	encrypted, err := keyring.Seal(sessionLabel, buf.Bytes())
+	if err != nil {
+		return xerrors.WithWrapper(ErrEncodeUser, err)
+	}
+	if err := deleteSession(ctx, req); err != nil {
+		return err
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	session := types.Session{ID: hashSessionID(id), UserID: user.ID,
+		Data: encrypted, Created: now, Expires: now.Add(sessionTTL)}
+	if err := sessions.CreateSession(ctx, &session); err != nil {
+		return xerrors.WithWrapper(ErrSession, err)
+	}
+	return writeCookieUser(resp, id)
+}
+
+func deleteSession(ctx context.Context, req *http.Request) error {
+	id, err := ReadSigned(req, cookieName)
+	if err != nil {
+		return nil
+	}
+	if err := sessions.DeleteSession(ctx, hashSessionID(id)); err != nil {
+		return xerrors.WithWrapper(ErrSession, err)
+	}
+	return nil
+}
+
+// DeleteCookieUser closes the session of the request.
+func DeleteCookieUser(resp http.ResponseWriter, req *http.Request) error {
+	expireCookie(resp, cookieName)
+	return deleteSession(req.Context(), req)
+}
+
+// DeleteUserSessions closes all the sessions of user, on every device.
+func DeleteUserSessions(resp http.ResponseWriter, req *http.Request, user *types.User) error {
+	if err := DeleteCookieUser(resp, req); err != nil {
+		return err
+	}
+	if err := sessions.DeleteSessions(req.Context(), user.ID); err != nil {
+		return xerrors.WithWrapper(ErrSession, err)
+	}
+	return nil
+}
+
+// migrateLegacyCookie opens a session for the user of a cookie issued before
+// sessions were stored server side, so upgrading doesn't log everyone out.
+// Both the signed and the encrypted formats are accepted, the user is found
+// by the email the cookie was issued for and must not have been linked to
+// another provider since. The legacy cookie is dropped either way.
+func migrateLegacyCookie(resp http.ResponseWriter, req *http.Request) (*types.User, error) {
+	ctx := req.Context()
+	defer expireCookie(resp, legacyCookieName)
+	if legacy == nil {
+		return nil, xerrors.New(ErrDecodingUser, "the session store can't convert legacy cookies")
+	}
+
+	encoded, err := ReadEncrypted(req, legacyCookieName)
+	if errors.Is(err, ErrInvalidValue) {
+		encoded, err = ReadSigned(req, legacyCookieName)
+	}
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	cookie := legacyUser{}
+	if err := gob.NewDecoder(strings.NewReader(encoded)).Decode(&cookie); err != nil {
+		return nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	user, err := legacy.GetUserByEmail(ctx, cookie.Email)
+	if err != nil {
+		return nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	if user.Provider != "" && user.Provider != legacyProvider {
+		return nil, xerrors.New(ErrDecodingUser, "user is linked to "+user.Provider)
+	}
+	// a token stored by a later login is more recent than the cookie one
+	_, err = legacy.GetToken(ctx, user.ID, legacyProvider)
+	switch {
+	case errors.Is(err, ErrSessionNotFound) && cookie.Token != "":
+		token := &oauth2.Token{AccessToken: cookie.Token, TokenType: "bearer"}
+		if err := legacy.SaveToken(ctx, user.ID, legacyProvider, token); err != nil {
+			return nil, xerrors.WithWrapper(ErrSession, err)
+		}
+	case err != nil && !errors.Is(err, ErrSessionNotFound):
+		return nil, xerrors.WithWrapper(ErrSession, err)
+	}
+	user.Provider = legacyProvider
+	if err := SetCookieUser(resp, req, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func GetCookieUser(req *http.Request) (*types.User, error) {
+	_, _, user, err := getCookieSession(req)
+	return user, err
+}
 
-	encoded, err := ReadSigned(req, cookieName)
+// getCookieSession also returns the identifier held by the client and the
+// stored session, needed to extend it.
+func getCookieSession(req *http.Request) (string, *types.Session, *types.User, error) {
+	id, err := ReadSigned(req, cookieName)
 	// better handle "invalid cookie", "cookie not found" as bad requests
 	if err != nil {
-		return nil, xerrors.WithWrapper(ErrDecodingUser, err)
+		return "", nil, nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	session, err := sessions.GetSession(req.Context(), hashSessionID(id))
+	if err != nil {
+		return "", nil, nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	decrypted, err := keyring.Open(sessionLabel, session.Data)
+	if err != nil {
+		return "", nil, nil, xerrors.WithWrapper(ErrDecodingUser, err)
 	}
 
-	user := types.User{}
-	reader := strings.NewReader(encoded)
+	payload := sessionData{}
+	reader := bytes.NewReader(decrypted)
 
-	if err := gob.NewDecoder(reader).Decode(&user); err != nil {
-		return nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	if err := gob.NewDecoder(reader).Decode(&payload); err != nil {
+		return "", nil, nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	user, err := sessions.GetUserByID(req.Context(), session.UserID)
+	if err != nil {
+		return "", nil, nil, xerrors.WithWrapper(ErrDecodingUser, err)
+	}
+	user.Provider = payload.Provider
+	return id, session, user, nil
+}
+
+// refreshSession slides the expiration of an active session once half of its
+// lifetime has elapsed, avoiding a write on every request.
+func refreshSession(resp http.ResponseWriter, req *http.Request, id string, session *types.Session) error {
+	if time.Until(session.Expires) > sessionTTL/2 {
+		return nil
+	}
+	if err := sessions.TouchSession(req.Context(), session.ID, time.Now().Add(sessionTTL)); err != nil {
+		return xerrors.WithWrapper(ErrSession, err)
 	}
-	return &user, nil
+	return writeCookieUser(resp, id)
 }
 
 func GetUser(r *http.Request) *types.User {
@@ -0,0 +1,18 @@
+package types
+
+import "time"
+
+// Collection groups starred repositories of a user under a name. ShareToken
+// is empty unless the collection is shared through a public link.
+type Collection struct {
+	ID, UserID int64
+	Name       string
+	ShareToken string
+	Created    time.Time
+	// Count is the number of repositories in the collection.
+	Count int
+}
+
+// Shared reports whether the collection can be read by anyone holding its
+// link.
+func (c *Collection) Shared() bool { return c.ShareToken != "" }
@@ -6,4 +6,14 @@ type Repository struct {
 	ID                                 int64
 	Owner, Name, Description, Language string
 	LastUpdated                        time.Time
+	HTMLURL                            string
+	Stars, Forks                       int
+	Topics                             []string
+	Archived, Fork                     bool
+}
+
+// LanguageCount is the number of repositories written in a language.
+type LanguageCount struct {
+	Language string
+	Count    int
 }
@@ -0,0 +1,13 @@
+package types
+
+import "time"
+
+// Session is the server side state of a logged in client. Data is opaque to
+// the storage, the server encrypts it before handing it over.
+type Session struct {
+	ID      string
+	UserID  int64
+	Data    []byte
+	Created time.Time
+	Expires time.Time
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// SyncState tracks the local copy of the starred repositories of a user.
+type SyncState struct {
+	UserID int64
+	// ETag identifies the last listing fetched, for conditional requests.
+	ETag string
+	// Checked is the last time the provider was asked for changes and Synced
+	// the last time the listing was fetched in full.
+	Checked time.Time
+	Synced  time.Time
+	// Error is the reason the last attempt failed, empty when it succeeded.
+	Error string
+}
@@ -1,15 +1,26 @@
 package types
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+	"time"
+)
 
 type User struct {
-	Name  string
-	Email string
-	Token string
+	// ID is the primary key of the user, zero until it has been saved.
+	ID     int64
+	Name   string
+	Email  string
+	Avatar string
+	// Provider is the name of the identity provider the user logged in with
+	// and Subject the identifier the provider knows the user by.
+	Provider  string
+	Subject   string
+	Created   time.Time
+	LastLogin time.Time
 }
 
-func NewUser(name, email, token string) *User {
-	return &User{Name: name, Email: email, Token: token}
+func NewUser(name, email string) *User {
+	return &User{Name: name, Email: email}
 }
 
 func init() {